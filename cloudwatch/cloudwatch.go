@@ -0,0 +1,242 @@
+// cloudwatch.go - AWS CloudWatch Logs output
+//
+// Copyright 2012, Sudhi Herle <sudhi -at- herle.net>
+// This code is licensed under the same terms as the golang core.
+
+// Package cloudwatch sends the log lines of go-logger to AWS CloudWatch
+// Logs via a caller supplied client. It is a separate module so that the
+// logger itself carries neither the API nor a dependency on the AWS SDK.
+package cloudwatch
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	logger "github.com/opencoff/go-logger"
+)
+
+const (
+	// PutLogEvents limits: a batch holds at most 10,000 events and
+	// 1,048,576 bytes; each event counts as its message length plus 26
+	// bytes.
+	_CW_MAXEVENTS = 10000
+	_CW_MAXBATCH  = 1048576
+	_CW_OVERHEAD  = 26
+
+	// Max length of a single event message
+	_CW_MAXMSG = 256*1024 - _CW_OVERHEAD
+
+	// interval between flushes of the pending batch
+	_CW_FLUSH = 5 * time.Second
+
+	// attempts to submit a batch with a stale sequence token
+	_CW_RETRIES = 3
+)
+
+// Event is a single log event submitted to CloudWatch Logs
+type Event struct {
+	Timestamp int64 // milliseconds since the Unix epoch
+	Message   string
+}
+
+// Client is the subset of the CloudWatch Logs API used by the
+// logger; callers adapt their AWS SDK client to it. This keeps the
+// logger free of a dependency on the AWS SDK.
+//
+// PutLogEvents submits 'ev' to the log stream 'stream' in the log group
+// 'group'; 'token' is the sequence token returned by the previous call
+// (empty for the first call). It returns the next sequence token. If
+// 'token' is stale, it must return an *InvalidSequenceTokenError.
+type Client interface {
+	PutLogEvents(group, stream, token string, ev []Event) (string, error)
+}
+
+// InvalidSequenceTokenError is returned by a Client when the
+// sequence token of a PutLogEvents call is stale.
+type InvalidSequenceTokenError struct {
+	ExpectedSequenceToken string
+}
+
+func (e *InvalidSequenceTokenError) Error() string {
+	return fmt.Sprintf("cloudwatch: invalid sequence token; expected %q", e.ExpectedSequenceToken)
+}
+
+// NewCloudWatch creates a new logger instance at the given priority that
+// sends each log line as an event to the CloudWatch Logs stream 'stream'
+// in the log group 'group' via 'c'. Log lines are buffered and submitted
+// in batches every 5 seconds, when a batch reaches the PutLogEvents
+// limits and when the logger is closed. Failures to submit a batch are
+// reported to the error handler (SetErrorHandler) and the batch is
+// discarded.
+//
+// Each event has the time of its log line rather than the time it was
+// submitted.
+func NewCloudWatch(c Client, group, stream string, prio logger.Priority, prefix string, flag int) (logger.Logger, error) {
+	return newCloudWatch(c, group, stream, prio, prefix, flag, _CW_FLUSH)
+}
+
+func newCloudWatch(c Client, group, stream string, prio logger.Priority, prefix string, flag int, d time.Duration) (logger.Logger, error) {
+	if c == nil {
+		return nil, fmt.Errorf("cloudwatch: nil client")
+	}
+	if len(group) == 0 || len(stream) == 0 {
+		return nil, fmt.Errorf("cloudwatch: empty log group or stream name")
+	}
+
+	w := &cwWriter{
+		c:      c,
+		group:  group,
+		stream: stream,
+		tick:   time.NewTicker(d),
+		done:   make(chan struct{}),
+	}
+	ll, err := logger.NewWithOptions(w, prio, prefix, flag, &logger.Options{CloseWriter: true})
+	if err != nil {
+		return nil, err
+	}
+
+	x := ll.(interface {
+		SetFormatter(logger.Formatter)
+		SetMaxLineLen(int)
+	})
+	x.SetFormatter(stamper{})
+	x.SetMaxLineLen(_CW_MAXMSG)
+
+	w.wg.Add(1)
+	go w.run()
+	return ll, nil
+}
+
+// stamper is a Formatter that renders log events in the default text
+// layout preceded by the time of the event (milliseconds since the Unix
+// epoch) and a space; cwWriter strips it to stamp the event.
+type stamper struct{}
+
+func (stamper) Format(b []byte, e logger.Event) []byte {
+	b = strconv.AppendInt(b, e.Time.UnixMilli(), 10)
+	b = append(b, ' ')
+	return logger.TextFormatter{}.Format(b, e)
+}
+
+// cwWriter batches log lines into PutLogEvents calls
+type cwWriter struct {
+	sync.Mutex
+	c             Client
+	group, stream string
+	token         string
+
+	ev   []Event // pending batch
+	size int     // size of the pending batch
+
+	err error // last failure of the periodic flush
+
+	tick *time.Ticker
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Write queues the log line 'b' in the pending batch
+func (w *cwWriter) Write(b []byte) (int, error) {
+	n := len(b)
+	if n > 0 && b[n-1] == '\n' {
+		b = b[:n-1]
+	}
+
+	ts := time.Now().UnixMilli()
+	if i := bytes.IndexByte(b, ' '); i > 0 {
+		if t, err := strconv.ParseInt(string(b[:i]), 10, 64); err == nil {
+			ts, b = t, b[i+1:]
+		}
+	}
+
+	// PutLogEvents rejects the whole batch if an event is empty
+	if len(b) == 0 {
+		return n, nil
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	err := w.err
+	w.err = nil
+
+	sz := len(b) + _CW_OVERHEAD
+	if len(w.ev) == _CW_MAXEVENTS || w.size+sz > _CW_MAXBATCH {
+		if xerr := w.flush(); xerr != nil {
+			err = xerr
+		}
+	}
+
+	w.ev = append(w.ev, Event{
+		Timestamp: ts,
+		Message:   string(b),
+	})
+	w.size += sz
+	return n, err
+}
+
+// Close submits the pending batch and stops the periodic flush
+func (w *cwWriter) Close() error {
+	close(w.done)
+	w.wg.Wait()
+
+	w.Lock()
+	defer w.Unlock()
+
+	err := w.flush()
+	if err == nil {
+		err = w.err
+	}
+	return err
+}
+
+// flush the pending batch every tick
+func (w *cwWriter) run() {
+	defer w.wg.Done()
+	defer w.tick.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-w.tick.C:
+			w.Lock()
+			if err := w.flush(); err != nil {
+				w.err = err
+			}
+			w.Unlock()
+		}
+	}
+}
+
+// submit the pending batch; the caller must hold the lock
+func (w *cwWriter) flush() error {
+	if len(w.ev) == 0 {
+		return nil
+	}
+
+	ev := w.ev
+	w.ev, w.size = nil, 0
+
+	var err error
+	for i := 0; i < _CW_RETRIES; i++ {
+		var tok string
+		if tok, err = w.c.PutLogEvents(w.group, w.stream, w.token, ev); err == nil {
+			w.token = tok
+			return nil
+		}
+
+		var ist *InvalidSequenceTokenError
+		if !errors.As(err, &ist) {
+			break
+		}
+		w.token = ist.ExpectedSequenceToken
+	}
+	return fmt.Errorf("cloudwatch: %s/%s: dropped %d events: %w", w.group, w.stream, len(ev), err)
+}
+
+// vim: ft=go:sw=8:ts=8:noexpandtab:tw=98:
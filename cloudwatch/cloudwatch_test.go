@@ -0,0 +1,143 @@
+package cloudwatch
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	logger "github.com/opencoff/go-logger"
+)
+
+func newAsserter(t *testing.T, pref string) func(cond bool, msg string, args ...any) {
+	return func(cond bool, msg string, args ...any) {
+		if !cond {
+			_, file, line, _ := runtime.Caller(1)
+			t.Fatalf("%s:%d: %s: %s", file, line, pref, fmt.Sprintf(msg, args...))
+		}
+	}
+}
+
+// mock CloudWatch Logs client
+type cwClient struct {
+	sync.Mutex
+	calls  [][]Event
+	tokens []string // token of each call
+	seq    int
+	want   string // expected token
+	stale  bool   // reject the next call with a stale token
+}
+
+func (c *cwClient) PutLogEvents(group, stream, token string, ev []Event) (string, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.stale {
+		c.stale = false
+		c.want = "tok-x"
+	}
+	if token != c.want {
+		return "", &InvalidSequenceTokenError{c.want}
+	}
+
+	c.seq++
+	c.calls = append(c.calls, append([]Event(nil), ev...))
+	c.tokens = append(c.tokens, token)
+	c.want = fmt.Sprintf("tok-%d", c.seq)
+	return c.want, nil
+}
+
+func (c *cwClient) events() int {
+	c.Lock()
+	defer c.Unlock()
+
+	n := 0
+	for _, ev := range c.calls {
+		n += len(ev)
+	}
+	return n
+}
+
+func TestCloudWatch(t *testing.T) {
+	assert := newAsserter(t, "cloudwatch")
+
+	c := &cwClient{}
+	ll, err := NewCloudWatch(c, "grp", "strm", logger.LOG_INFO, "web", 0)
+	assert(err == nil, "can't create log: %s", err)
+
+	const n = 25000
+	for i := 0; i < n; i++ {
+		ll.Info("msg %d", i)
+	}
+	ll.Close()
+
+	// banner + n lines + close
+	assert(c.events() == n+2, "exp %d events, saw %d", n+2, c.events())
+	assert(len(c.calls) >= 3, "exp at least 3 batches, saw %d", len(c.calls))
+
+	for i, ev := range c.calls {
+		assert(len(ev) <= _CW_MAXEVENTS, "batch %d: %d events", i, len(ev))
+
+		exp := ""
+		if i > 0 {
+			exp = fmt.Sprintf("tok-%d", i)
+		}
+		assert(c.tokens[i] == exp, "batch %d: exp token %q, saw %q", i, exp, c.tokens[i])
+	}
+
+	ev := c.calls[0][1]
+	assert(strings.HasSuffix(ev.Message, "[web] msg 0"), "unexpected message %q", ev.Message)
+	assert(!strings.HasSuffix(ev.Message, "\n"), "message has a newline: %q", ev.Message)
+	assert(ev.Timestamp > 0, "missing timestamp")
+}
+
+func TestCloudWatchTimestamp(t *testing.T) {
+	assert := newAsserter(t, "cloudwatch-timestamp")
+
+	c := &cwClient{}
+	ll, err := NewCloudWatch(c, "grp", "strm", logger.LOG_INFO, "", 0)
+	assert(err == nil, "can't create log: %s", err)
+
+	// the event has the time of the log line - not of its submission
+	ts := time.Date(2024, 3, 5, 7, 8, 9, 123456789, time.UTC)
+	ll.(interface{ SetClock(func() time.Time) }).SetClock(func() time.Time { return ts })
+	ll.Info("hello")
+	ll.Info("")
+	fmt.Fprintf(ll.Writer(), "raw line")
+	ll.Close()
+
+	assert(c.events() == 4, "exp 4 events, saw %d", c.events())
+	for _, ev := range c.calls[0][1:3] {
+		assert(ev.Timestamp == ts.UnixMilli(), "exp timestamp %d, saw %d", ts.UnixMilli(), ev.Timestamp)
+	}
+
+	ev := c.calls[0][1]
+	assert(strings.HasPrefix(ev.Message, "<2>:2024/03/05 07:08:09.123"), "unexpected message %q", ev.Message)
+	assert(strings.HasSuffix(ev.Message, "hello"), "unexpected message %q", ev.Message)
+	assert(strings.HasSuffix(c.calls[0][2].Message, " raw line"), "unexpected raw message %q", c.calls[0][2].Message)
+}
+
+func TestCloudWatchToken(t *testing.T) {
+	assert := newAsserter(t, "cloudwatch-token")
+
+	c := &cwClient{stale: true}
+	ll, err := newCloudWatch(c, "grp", "strm", logger.LOG_INFO, "", 0, 10*time.Millisecond)
+	assert(err == nil, "can't create log: %s", err)
+
+	ll.Info("hello")
+
+	// the periodic flush must submit the batch before Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for c.events() < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert(c.events() == 2, "periodic flush: saw %d events", c.events())
+	assert(c.tokens[0] == "tok-x", "stale token not retried: %q", c.tokens[0])
+
+	ll.Info("world")
+	ll.Close()
+	assert(c.events() == 4, "close: saw %d events", c.events())
+	assert(c.tokens[1] == "tok-1", "token didn't advance: %q", c.tokens[1])
+}
@@ -0,0 +1,5 @@
+module github.com/opencoff/go-logger/cloudwatch
+
+go 1.22
+
+require github.com/opencoff/go-logger v0.0.0-20261014122941-6ff4079c3866
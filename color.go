@@ -0,0 +1,71 @@
+// color.go - colorized log levels for terminals
+//
+// Copyright 2012, Sudhi Herle <sudhi -at- herle.net>
+// This code is licensed under the same terms as the golang core.
+
+package logger
+
+import (
+	"io"
+	"os"
+)
+
+const _ANSI_RESET = "\x1b[0m"
+
+// ANSI color for each priority
+var prioColor = map[Priority]string{
+	LOG_DEBUG:  "\x1b[90m",   // gray
+	LOG_INFO:   "\x1b[32m",   // green
+	LOG_NOTICE: "\x1b[36m",   // cyan
+	LOG_WARN:   "\x1b[33m",   // yellow
+	LOG_ERR:    "\x1b[31m",   // red
+	LOG_CRIT:   "\x1b[1;31m", // bold red
+	LOG_ALERT:  "\x1b[1;35m", // bold magenta
+	LOG_EMERG:  "\x1b[1;41m", // bold on red
+}
+
+// return 'flag' with lColor set iff colorized output is requested and
+// possible on 'w'. Colors are never written to files or syslog. The
+// precedence is: Lforcecolor, then a non-empty NO_COLOR environment
+// variable (see https://no-color.org) and lastly terminal detection.
+func colorize(flag int, w io.Writer) int {
+	flag &= ^lColor
+	if (flag & (lSyslog | lClose)) != 0 {
+		return flag
+	}
+
+	if (flag & Lforcecolor) != 0 {
+		return flag | lColor
+	}
+
+	if len(os.Getenv("NO_COLOR")) > 0 {
+		return flag
+	}
+
+	if (flag&Lcolor) != 0 && isTerminal(w) {
+		return flag | lColor
+	}
+	return flag
+}
+
+// return true if 'w' is a terminal
+var isTerminal = func(w io.Writer) bool {
+	if fd, ok := w.(interface{ Fd() uintptr }); ok {
+		return isatty(fd.Fd())
+	}
+	return false
+}
+
+// append 's' to 'b' wrapped in the ANSI color for 'prio'
+func appendColor(b []byte, prio Priority, s []byte) []byte {
+	c, ok := prioColor[prio]
+	if !ok {
+		return append(b, s...)
+	}
+
+	b = append(b, c...)
+	b = append(b, s...)
+	return append(b, _ANSI_RESET...)
+}
+
+// vim: ft=go:sw=8:ts=8:noexpandtab:tw=98:
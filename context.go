@@ -0,0 +1,30 @@
+// context.go - carry loggers in a context.Context
+//
+// Copyright 2012, Sudhi Herle <sudhi -at- herle.net>
+// This code is licensed under the same terms as the golang core.
+
+package logger
+
+import (
+	"context"
+)
+
+// unexported key type to avoid collisions with other packages
+type ctxKey struct{}
+
+// NewContext returns a copy of 'ctx' that carries the logger 'l'.
+// Typically 'l' is a sub-logger with a request specific prefix.
+func NewContext(ctx context.Context, l Logger) context.Context {
+	return context.WithValue(ctx, ctxKey{}, l)
+}
+
+// FromContext returns the logger stored in 'ctx' by NewContext(); if
+// there is none, it returns a null logger that discards everything.
+func FromContext(ctx context.Context) Logger {
+	if l, ok := ctx.Value(ctxKey{}).(Logger); ok {
+		return l
+	}
+	return newNullLogger("", LOG_NONE)
+}
+
+// vim: ft=go:sw=8:ts=8:noexpandtab:tw=98:
@@ -0,0 +1,130 @@
+// dedup.go - suppress consecutive duplicate log lines
+//
+// Copyright 2012, Sudhi Herle <sudhi -at- herle.net>
+// This code is licensed under the same terms as the golang core.
+
+package logger
+
+import (
+	"encoding/binary"
+	"hash/maphash"
+	"time"
+)
+
+// dedup tracks consecutive identical log lines; it is only ever accessed
+// from qrunner.
+type dedup struct {
+	last  uint64      // key of the last line written
+	n     int         // number of times 'last' was repeated
+	timer *time.Timer // flushes the repeat count
+}
+
+// SetDedup enables suppression of consecutive identical log lines: instead
+// of writing a repeated line, a count is kept and a single "last message
+// repeated N times" line is written when a different line arrives or
+// after 'd' has elapsed. Lines are compared by their priority, prefix,
+// call site, message and fields; the timestamp is ignored.
+// A value of d <= 0 disables suppression (the default). The setting is
+// shared by all sub-loggers.
+func (l *xLogger) SetDedup(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	l.ch.ddwait.Store(int64(d))
+}
+
+// return the channel that fires when the repeat count must be flushed
+func (d *dedup) expired() <-chan time.Time {
+	if d.timer == nil || d.n == 0 {
+		return nil
+	}
+	return d.timer.C
+}
+
+// return true if 'e' is a repeat of the previous line and can be
+// suppressed. Called only from qrunner.
+func (l *xLogger) repeated(e *qev) bool {
+	wait := time.Duration(l.ch.ddwait.Load())
+	if wait == 0 {
+		return false
+	}
+
+	d := &l.ch.dd
+	key := e.key
+	if key == 0 {
+		// raw writes: compare the text after the timestamp
+		key = bufKey(e.prio, e.buf[e.moff:])
+	}
+	if key == d.last {
+		if d.n == 0 {
+			if d.timer == nil {
+				d.timer = time.NewTimer(wait)
+			} else {
+				d.timer.Reset(wait)
+			}
+		}
+		d.n++
+		return true
+	}
+
+	l.flushRepeats()
+	d.last = key
+	return false
+}
+
+// seed of the dedup keys
+var ddseed = maphash.MakeSeed()
+
+// return the dedup key of the log message 'e': a hash of everything but
+// the time. Keys are never zero.
+func dedupKey(e *Event) uint64 {
+	var h maphash.Hash
+	var n [8]byte
+
+	h.SetSeed(ddseed)
+	h.WriteByte(byte(e.Prio))
+	h.WriteString(e.Prefix)
+	h.WriteByte(0)
+	h.WriteString(e.File)
+	h.Write(binary.LittleEndian.AppendUint64(n[:0], uint64(e.Line)))
+	h.WriteString(e.Func)
+	h.WriteByte(0)
+	h.Write(e.Msg)
+	h.WriteByte(0)
+	h.WriteString(e.fldstr)
+	return nonzero(h.Sum64())
+}
+
+// return the dedup key of the rendered line 'b' at priority 'prio'
+func bufKey(prio Priority, b []byte) uint64 {
+	var h maphash.Hash
+
+	h.SetSeed(ddseed)
+	h.WriteByte(byte(prio))
+	h.Write(b)
+	return nonzero(h.Sum64())
+}
+
+func nonzero(k uint64) uint64 {
+	if k == 0 {
+		return 1
+	}
+	return k
+}
+
+// write out the pending repeat count (if any). Called only from qrunner.
+func (l *xLogger) flushRepeats() {
+	d := &l.ch.dd
+	if d.n == 0 {
+		return
+	}
+
+	n := d.n
+	d.n = 0
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+	l.dprintf(0, LOG_INFO, "last message repeated %d times", n)
+}
+
+// vim: ft=go:sw=8:ts=8:noexpandtab:tw=98:
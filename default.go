@@ -0,0 +1,116 @@
+// default.go - package level default logger
+//
+// Copyright 2012, Sudhi Herle <sudhi -at- herle.net>
+// This code is licensed under the same terms as the golang core.
+
+package logger
+
+import (
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// holder for the default logger; atomic.Pointer needs a concrete type.
+type defLogger struct {
+	Logger
+}
+
+var (
+	defLog  atomic.Pointer[defLogger]
+	defOnce sync.Once
+)
+
+// Default returns the package level default logger. Unless changed via
+// SetDefault(), it logs to STDERR at level LOG_WARN.
+func Default() Logger {
+	if d := defLog.Load(); d != nil {
+		return d.Logger
+	}
+
+	// create the default logger only when someone needs it; we don't
+	// want the startup banner for programs that never use it.
+	defOnce.Do(func() {
+		ll, _ := New(os.Stderr, LOG_WARN, "", 0)
+		defLog.CompareAndSwap(nil, &defLogger{ll})
+	})
+	return defLog.Load().Logger
+}
+
+// SetDefault makes 'l' the package level default logger used by the
+// package level log functions. It is safe to call concurrently with
+// the log functions. The previous default logger is NOT closed.
+func SetDefault(l Logger) {
+	if l == nil {
+		l = newNullLogger("", LOG_NONE)
+	}
+	defLog.Store(&defLogger{l})
+}
+
+// Fatal writes a log message with stack backtrace to the default logger,
+// closes it and exits the program with code 1
+func Fatal(format string, v ...interface{}) {
+	if l, ok := Default().(*xLogger); ok {
+		l.fatal(1, format, v...)
+	} else {
+		Default().Fatal(format, v...)
+	}
+}
+
+// Alert writes a log message to the default logger at level LOG_ALERT
+func Alert(format string, v ...interface{}) {
+	output(LOG_ALERT, format, v...)
+}
+
+// Crit writes a log message to the default logger at level LOG_CRIT
+func Crit(format string, v ...interface{}) {
+	output(LOG_CRIT, format, v...)
+}
+
+// Error writes a log message to the default logger at level LOG_ERR
+func Error(format string, v ...interface{}) {
+	output(LOG_ERR, format, v...)
+}
+
+// Warn writes a log message to the default logger at level LOG_WARN
+func Warn(format string, v ...interface{}) {
+	output(LOG_WARN, format, v...)
+}
+
+// Notice writes a log message to the default logger at level LOG_NOTICE
+func Notice(format string, v ...interface{}) {
+	output(LOG_NOTICE, format, v...)
+}
+
+// Info writes a log message to the default logger at level LOG_INFO
+func Info(format string, v ...interface{}) {
+	output(LOG_INFO, format, v...)
+}
+
+// Debug writes a log message to the default logger at level LOG_DEBUG
+func Debug(format string, v ...interface{}) {
+	output(LOG_DEBUG, format, v...)
+}
+
+// write a log message at level 'prio' to the default logger. We go
+// directly to Output() for our own logger so that the caller's file &
+// line are recorded correctly; errors get a backtrace as with the
+// methods (see SetErrorBacktrace).
+func output(prio Priority, format string, v ...interface{}) {
+	switch l := Default().(type) {
+	case *xLogger:
+		if !l.enabled(prio, format) {
+			return
+		}
+		if prio == LOG_ERR || prio == LOG_CRIT {
+			l.errOutput(3, prio, format, v...)
+		} else {
+			l.Output(3, prio, format, v...)
+		}
+
+	default:
+		l.Log(prio, format, v...)
+	}
+}
+
+// vim: ft=go:sw=8:ts=8:noexpandtab:tw=98:
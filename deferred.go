@@ -0,0 +1,78 @@
+// deferred.go - formatting of log lines in the I/O goroutine
+//
+// Copyright 2012, Sudhi Herle <sudhi -at- herle.net>
+// This code is licensed under the same terms as the golang core.
+
+package logger
+
+import (
+	"runtime"
+	"time"
+)
+
+// call site of a log message captured by the caller
+type callsite struct {
+	pc   uintptr
+	file string
+	line int
+	ok   bool
+}
+
+// arguments of a log message that is formatted by qrunner
+type dfmtArgs struct {
+	l    *xLogger // logger (or sub-logger) of the message
+	t    time.Time
+	cs   *callsite // nil without Lfileloc
+	prio Priority
+	s    string
+	v    []any
+}
+
+// SetDeferredFormat moves the formatting of log lines from the caller
+// to the I/O goroutine: the caller only captures the time, the call site
+// (with Lfileloc), the format string and a copy of the arguments, and
+// qrunner renders the line before writing it. This reduces the latency
+// of a log call at the cost of the following caveats:
+//
+//   - the arguments are formatted after the call returns. Values
+//     referenced by the arguments (pointers, slices, maps) must not be
+//     modified until the line is written, or the line shows the modified
+//     values.
+//   - the String() and Error() methods of the arguments run in the I/O
+//     goroutine, concurrently with the caller; they must be safe for
+//     concurrent use.
+//   - the arguments are retained until the line is written.
+//   - a panic while formatting is recovered by the I/O goroutine and the
+//     line is lost.
+//
+// Deferral is shared by all sub-loggers and has no effect on a
+// synchronous logger (Options.Synchronous).
+func (l *xLogger) SetDeferredFormat(on bool) {
+	l.ch.deferfmt.Store(on)
+}
+
+// capture a log message to be formatted by qrunner
+func (l *xLogger) dfmt(calldepth int, prio Priority, s string, v []any) qev {
+	l.mu.Lock()
+	flag, clock, cskip := l.flag, l.now, l.cskip
+	l.mu.Unlock()
+
+	d := &dfmtArgs{
+		l:    l,
+		t:    clock(),
+		prio: prio,
+		s:    s,
+	}
+	if len(v) > 0 {
+		d.v = append([]any(nil), v...)
+	}
+
+	if calldepth > 0 && (flag&Lfileloc) > 0 {
+		cs := &callsite{}
+		cs.pc, cs.file, cs.line, cs.ok = runtime.Caller(calldepth + cskip)
+		d.cs = cs
+	}
+	return qev{ty: _QEV_LOG, prio: prio, dfr: d}
+}
+
+// vim: ft=go:sw=8:ts=8:noexpandtab:tw=98:
@@ -0,0 +1,76 @@
+package logger
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestDeferredFormat(t *testing.T) {
+	assert := newAsserter(t, "deferred")
+
+	logit := func(deferred bool) (string, int) {
+		var b bytes.Buffer
+		ll, err := NewWithOptions(&b, LOG_INFO, "app", Lfileloc|Lerrchain, &Options{QueueDepth: 16, Quiet: true})
+		assert(err == nil, "can't create log: %s", err)
+
+		ll.(*xLogger).SetDeferredFormat(deferred)
+
+		args := []any{"a", 42}
+		_, _, line, _ := runtime.Caller(0)
+		ll.Info("%s=%d", args...)
+		args[0] = "b"
+		ll.New("sub", 0).Warn("failed: %v", fmt.Errorf("wrapped: %w", errors.New("root")))
+		ll.Info("plain")
+		ll.Close()
+		return b.String(), line
+	}
+
+	exp, _ := logit(false)
+	out, line := logit(true)
+	assert(out == exp, "deferred output differs:\nexp:\n%s\nsaw:\n%s", exp, out)
+
+	exps := []struct {
+		off int
+		msg string
+	}{
+		{1, "a=42"},
+		{3, "failed: wrapped: root"},
+		{4, "plain"},
+	}
+	for _, x := range exps {
+		m := fmt.Sprintf("(deferred_test.go:%d) %s\n", line+x.off, x.msg)
+		assert(strings.Contains(out, m), "exp %q:\n%s", m, out)
+	}
+}
+
+func benchmarkDeferred(b *testing.B, deferred bool) {
+	ll, err := NewWithOptions(io.Discard, LOG_DEBUG, "bench", 0, &Options{QueueDepth: 4096, Quiet: true})
+	if err != nil {
+		b.Fatalf("can't create log: %s", err)
+	}
+
+	// measure the caller alone: don't wait for the I/O goroutine
+	x := ll.(*xLogger)
+	x.SetOverflowPolicy(DropNewest)
+	x.SetDeferredFormat(deferred)
+
+	v := struct {
+		Name string
+		Port int
+	}{"server", 8080}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ll.Info("busy line %d: %+v %q %.3f", i, v, "some text", 3.14159)
+	}
+	b.StopTimer()
+	ll.Close()
+}
+
+func BenchmarkInlineFormat(b *testing.B)   { benchmarkDeferred(b, false) }
+func BenchmarkDeferredFormat(b *testing.B) { benchmarkDeferred(b, true) }
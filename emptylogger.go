@@ -8,12 +8,18 @@
 
 package logger
 
+import (
+	"fmt"
+	"time"
+)
+
 type emptyLogger struct {
 	prio   Priority
 	prefix string
 }
 
 var _ Logger = &emptyLogger{}
+var _ RotatableLogger = &emptyLogger{}
 
 func newNullLogger(pref string, prio Priority) *emptyLogger {
 	return &emptyLogger{
@@ -26,20 +32,80 @@ func (e *emptyLogger) New(pref string, prio Priority) Logger {
 	return newNullLogger(pref, prio)
 }
 
+func (e *emptyLogger) Clone() Logger {
+	return newNullLogger(e.prefix, e.prio)
+}
+
 func (e *emptyLogger) Close() error {
 	return nil
 }
 
+func (e *emptyLogger) CloseTimeout(d time.Duration) error {
+	return nil
+}
+
+func (e *emptyLogger) IsClosed() bool {
+	return false
+}
+
+func (e *emptyLogger) EnableRotation(hh, mm, ss int, keep int) error {
+	return nil
+}
+
+func (e *emptyLogger) RotateHourly(mm, ss int, keep int) error {
+	return nil
+}
+
+func (e *emptyLogger) RotateWeekly(day time.Weekday, hh, mm, ss int, keep int) error {
+	return nil
+}
+
+func (e *emptyLogger) Sync() error {
+	return nil
+}
+
+func (e *emptyLogger) ConfigureRotation(c *RotateConfig) error {
+	return nil
+}
+
+func (e *emptyLogger) Flush() error {
+	return nil
+}
+
+func (e *emptyLogger) Rotate() error {
+	return nil
+}
+
+func (e *emptyLogger) Name() string {
+	return ""
+}
+
 func (e *emptyLogger) Loggable(p Priority) bool {
-	return e.prio > LOG_NONE && p >= e.prio
+	return levelEnabled(e.prio, p)
+}
+
+func (e *emptyLogger) LevelEnabled(p Priority) bool {
+	return levelEnabled(e.prio, p)
+}
+
+func (e *emptyLogger) Fatal(s string, v ...interface{})  {}
+func (e *emptyLogger) Alert(s string, v ...interface{})  {}
+func (e *emptyLogger) Crit(s string, v ...interface{})   {}
+func (e *emptyLogger) Error(s string, v ...interface{})  {}
+func (e *emptyLogger) Warn(s string, v ...interface{})   {}
+func (e *emptyLogger) Notice(s string, v ...interface{}) {}
+func (e *emptyLogger) Info(s string, v ...interface{})   {}
+func (e *emptyLogger) Debug(s string, v ...interface{})  {}
+
+func (e *emptyLogger) Log(p Priority, s string, v ...interface{}) {}
+
+func (e *emptyLogger) Critf(s string, v ...interface{}) error {
+	return fmt.Errorf(s, v...)
 }
 
-func (e *emptyLogger) Fatal(s string, v ...interface{}) {}
-func (e *emptyLogger) Crit(s string, v ...interface{})  {}
-func (e *emptyLogger) Error(s string, v ...interface{}) {}
-func (e *emptyLogger) Warn(s string, v ...interface{})  {}
-func (e *emptyLogger) Info(s string, v ...interface{})  {}
-func (e *emptyLogger) Debug(s string, v ...interface{}) {}
+func (e *emptyLogger) Errorf(s string, v ...interface{}) error {
+	return fmt.Errorf(s, v...)
+}
 
 func (e *emptyLogger) Prio() Priority {
 	return e.prio
@@ -48,3 +114,28 @@ func (e *emptyLogger) Prio() Priority {
 func (e *emptyLogger) Prefix() string {
 	return e.prefix
 }
+
+func (e *emptyLogger) WithFields(kv map[string]any) Logger {
+	return e
+}
+
+func (e *emptyLogger) Infow(msg string, kv ...any)  {}
+func (e *emptyLogger) Warnw(msg string, kv ...any)  {}
+func (e *emptyLogger) Errorw(msg string, kv ...any) {}
+
+func (e *emptyLogger) Printf(format string, v ...interface{}) {}
+func (e *emptyLogger) Print(v ...interface{})                 {}
+func (e *emptyLogger) Println(v ...interface{})               {}
+
+func (e *emptyLogger) Event(prio Priority) *Entry {
+	return nil
+}
+
+func (e *emptyLogger) DebugFn(fn func() string) {}
+func (e *emptyLogger) InfoFn(fn func() string)  {}
+func (e *emptyLogger) WarnFn(fn func() string)  {}
+func (e *emptyLogger) ErrorFn(fn func() string) {}
+
+func (e *emptyLogger) StackTrace(depth int) string {
+	return ""
+}
@@ -0,0 +1,217 @@
+// fields.go - structured key=value logging
+//
+// Copyright 2012, Sudhi Herle <sudhi -at- herle.net>
+// This code is licensed under the same terms as the golang core.
+
+package logger
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A Field is a single key=value pair attached to a logger
+type Field struct {
+	Key   string
+	Value any
+}
+
+// WithFields creates a sub-logger that appends the key=value pairs in 'kv'
+// to every log line (after the message). Fields accumulate across chained
+// calls; a key in 'kv' overrides the same key in the parent. The sub-logger
+// shares the output channel of its parent.
+func (l *xLogger) WithFields(kv map[string]any) Logger {
+	nl := l.sublogger(l.Prio())
+	if len(kv) == 0 {
+		return nl
+	}
+
+	fv := make([]Field, 0, len(l.fields)+len(kv))
+	for _, f := range l.fields {
+		if _, ok := kv[f.Key]; !ok {
+			fv = append(fv, f)
+		}
+	}
+	for k, v := range kv {
+		fv = append(fv, Field{k, v})
+	}
+
+	// map iteration order is random; keep the output stable
+	sort.Slice(fv, func(i, j int) bool {
+		return fv[i].Key < fv[j].Key
+	})
+
+	nl.fields = fv
+	nl.fldstr = string(appendFields(nil, fv))
+	return nl
+}
+
+// Infow writes the message 'msg' at level INFO followed by the key=value
+// pairs in 'kv' - a list of alternating keys and values. A trailing key
+// without a value is rendered as !BADKEY=key.
+func (l *xLogger) Infow(msg string, kv ...any) {
+	if l.enabled(LOG_INFO, msg) {
+		l.outputw(LOG_INFO, msg, kv)
+	}
+}
+
+// Warnw is like Infow but writes at level WARNING
+func (l *xLogger) Warnw(msg string, kv ...any) {
+	if l.enabled(LOG_WARN, msg) {
+		l.outputw(LOG_WARN, msg, kv)
+	}
+}
+
+// Errorw is like Infow but writes at level ERR
+func (l *xLogger) Errorw(msg string, kv ...any) {
+	if l.enabled(LOG_ERR, msg) {
+		l.outputw(LOG_ERR, msg, kv)
+	}
+}
+
+// An Entry is a structured log line under construction; see Event().
+// The methods of a nil Entry do nothing.
+type Entry struct {
+	l    *xLogger
+	prio Priority
+	kv   []any
+}
+
+// Event starts a structured log line at level 'prio': fields are added to
+// the returned Entry and the line is written by its Msg() method; e.g.,
+//
+//	log.Event(LOG_INFO).Str("user", u).Int("n", n).Msg("login")
+//
+// Event returns nil if 'prio' is disabled: the Entry methods then do
+// nothing and cost no allocations.
+func (l *xLogger) Event(prio Priority) *Entry {
+	if !l.enabled(prio, "") {
+		return nil
+	}
+	return &Entry{l: l, prio: prio}
+}
+
+// Str adds the field k=v
+func (e *Entry) Str(k, v string) *Entry {
+	if e != nil {
+		e.kv = append(e.kv, k, v)
+	}
+	return e
+}
+
+// Int adds the field k=v
+func (e *Entry) Int(k string, v int) *Entry {
+	if e != nil {
+		e.kv = append(e.kv, k, v)
+	}
+	return e
+}
+
+// Err adds the field error=err; a nil err isn't added
+func (e *Entry) Err(err error) *Entry {
+	if e != nil && err != nil {
+		e.kv = append(e.kv, "error", err)
+	}
+	return e
+}
+
+// Msg writes the log line with the message 'msg' followed by the fields
+// of the entry.
+func (e *Entry) Msg(msg string) {
+	if e != nil {
+		e.l.outputw(e.prio, msg, e.kv)
+	}
+}
+
+// write 'msg' with the per-message fields 'kv' after the logger's fields
+func (l *xLogger) outputw(prio Priority, msg string, kv []any) {
+	if len(kv) == 0 {
+		l.Output(3, prio, "%s", msg)
+		return
+	}
+
+	fv := make([]Field, 0, len(kv)/2+1)
+	for i := 0; i < len(kv); i += 2 {
+		if i+1 == len(kv) {
+			fv = append(fv, Field{"!BADKEY", kv[i]})
+			break
+		}
+
+		k, ok := kv[i].(string)
+		if !ok {
+			k = fmt.Sprint(kv[i])
+		}
+		fv = append(fv, Field{k, kv[i+1]})
+	}
+
+	// per-message fields override the logger's
+	nl := l.sublogger(l.Prio())
+	nl.fields = make([]Field, 0, len(l.fields)+len(fv))
+	for _, f := range l.fields {
+		if !hasField(fv, f.Key) {
+			nl.fields = append(nl.fields, f)
+		}
+	}
+	nl.fields = append(nl.fields, fv...)
+	nl.fldstr = string(appendFields(nil, nl.fields))
+	nl.Output(3, prio, "%s", msg)
+}
+
+// return true if 'fv' has a field named 'k'
+func hasField(fv []Field, k string) bool {
+	for i := range fv {
+		if fv[i].Key == k {
+			return true
+		}
+	}
+	return false
+}
+
+// render the fields as " k=v" pairs
+func appendFields(b []byte, fv []Field) []byte {
+	for i := range fv {
+		f := &fv[i]
+		b = append(b, ' ')
+		b = append(b, f.Key...)
+		b = append(b, '=')
+		b = appendValue(b, f.Value)
+	}
+	return b
+}
+
+// render a value; strings that are empty or have spaces, quotes or '='
+// are quoted.
+func appendValue(b []byte, v any) []byte {
+	var s string
+
+	switch x := v.(type) {
+	case string:
+		s = x
+	case error:
+		s = x.Error()
+	case fmt.Stringer:
+		s = x.String()
+	default:
+		s = fmt.Sprint(v)
+	}
+
+	if needsQuote(s) {
+		return strconv.AppendQuote(b, s)
+	}
+	return append(b, s...)
+}
+
+// return true if 's' needs to be quoted to be unambiguous
+func needsQuote(s string) bool {
+	if len(s) == 0 {
+		return true
+	}
+
+	return strings.IndexFunc(s, func(r rune) bool {
+		return r <= ' ' || r == '=' || r == '"' || r == 0x7f
+	}) >= 0
+}
+
+// vim: ft=go:sw=8:ts=8:noexpandtab:tw=98:
@@ -0,0 +1,212 @@
+// format.go - pluggable formatting of log events
+//
+// Copyright 2012, Sudhi Herle <sudhi -at- herle.net>
+// This code is licensed under the same terms as the golang core.
+
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// An Event describes a single log message handed to a Formatter.
+type Event struct {
+	Prio  Priority  // priority of the message
+	Time  time.Time // time of the message (UTC)
+	Start time.Time // start time of the logger; reference for Lreltime
+	Flags int       // logger flags in effect for the message
+
+	Prefix string // logger prefix (if any) - e.g., "[foo] "
+	File   string // source file of the caller; set only if Lfileloc is set
+	Line   int    // source line of the caller
+	Func   string // function name of the caller; set only if Lfunc is set
+
+	// Msg is the formatted message without a trailing newline; redaction
+	// and truncation have already been applied. Formatters must not
+	// retain it beyond the call to Format().
+	Msg []byte
+
+	// Fields is the list of key=value pairs attached to the logger
+	Fields []Field
+
+	fldstr string     // pre-rendered 'Fields'
+	pri    *syslogPRI // syslog PRI marker (if any)
+}
+
+// A Formatter renders a log event into a complete log record. Format
+// appends the record to 'buf' and returns the extended buffer; text
+// formatters are expected to terminate the record with a newline.
+// Format is called concurrently from every goroutine that logs and must
+// be safe for concurrent use.
+type Formatter interface {
+	Format(buf []byte, e Event) []byte
+}
+
+// TextFormatter renders log events in the default text layout of the
+// logger:
+//
+//	<prio>:TIMESTAMP [prefix] host[pid] (file:line func) message k=v..
+type TextFormatter struct{}
+
+var _ Formatter = TextFormatter{}
+
+// Format renders 'e' in the default text layout
+func (TextFormatter) Format(b []byte, e Event) []byte {
+	b, _, _, _ = appendText(b, &e)
+	return b
+}
+
+// SetFormatter sets the formatter that renders each log message; a nil
+// formatter restores the default text layout. Sub-loggers created after
+// this call inherit the formatter.
+func (l *xLogger) SetFormatter(f Formatter) {
+	l.mu.Lock()
+	l.fmtr = f
+	l.mu.Unlock()
+
+	l.stdlogger.Store(nil)
+}
+
+// SetLineEnding sets the terminator of each log line to 'eol' - e.g.,
+// "\r\n" for consumers on Windows; an empty string restores the default
+// of "\n". The terminator is applied to the output of custom formatters
+// and to lines written via StdLogger() as well.
+func (l *xLogger) SetLineEnding(eol string) {
+	if eol == "\n" {
+		eol = ""
+	}
+
+	l.mu.Lock()
+	l.eol = eol
+	l.mu.Unlock()
+}
+
+// replace the trailing newline of 'b' with 'eol' (if set)
+func setEOL(b []byte, eol string) []byte {
+	if n := len(b); len(eol) > 0 && n > 0 && b[n-1] == '\n' {
+		b = append(b[:n-1], eol...)
+	}
+	return b
+}
+
+// render 'e' in the text layout; return the extended buffer, the offset
+// of the timestamp, the offset of the text following the timestamp and
+// the offset of the fields.
+func appendText(b []byte, e *Event) ([]byte, int, int, int) {
+	var hoff int
+
+	// Put the timestamp and priority only if we are NOT syslog
+	if (e.Flags & lSyslog) == 0 {
+		var m [16]byte
+
+		mark := m[:0]
+		if (e.Flags & Llevelname) != 0 {
+			mark = append(mark, e.Prio.String()...)
+			mark = append(mark, ':')
+		} else if e.pri != nil {
+			mark = fmt.Appendf(mark, "<%d>:", e.pri.fac+e.pri.sev[e.Prio])
+		} else {
+			mark = fmt.Appendf(mark, "<%d>:", e.Prio)
+		}
+
+		if (e.Flags & lColor) != 0 {
+			b = appendColor(b, e.Prio, mark)
+		} else {
+			b = append(b, mark...)
+		}
+
+		if (e.Flags & Lcolumns) != 0 {
+			b = append(b, ' ')
+		}
+		hoff = len(b)
+		b = appendHeader(b, e)
+		if len(b) == hoff && (e.Flags&Lcolumns) != 0 {
+			// no timestamp; don't leave an empty column
+			b = b[:hoff-1]
+			hoff--
+		}
+		b = append(b, ' ')
+	}
+	moff := len(b)
+
+	b = appendBody(b, e)
+	foff := len(b)
+	if len(e.fldstr) > 0 {
+		b = append(b, e.fldstr...)
+	} else {
+		b = appendFields(b, e.Fields)
+	}
+	return append(b, '\n'), hoff, moff, foff
+}
+
+// render the timestamp of 'e'
+func appendHeader(b []byte, e *Event) []byte {
+	if (e.Flags & Lreltime) == 0 {
+		return timestamp(b, e.Time, e.Flags)
+	}
+
+	d := e.Time.Sub(e.Start)
+	if (e.Flags & Lrelseconds) == 0 {
+		return fmt.Appendf(b, "+%s", d.String())
+	}
+
+	sign := byte('+')
+	if d < 0 {
+		sign, d = '-', -d
+	}
+	return fmt.Appendf(b, "%c%d.%09d", sign, d/time.Second, d%time.Second)
+}
+
+// append the chain of wrapped errors of each error in 'v' to 'b'; each
+// wrapped error is on its own indented line.
+func appendErrChain(b []byte, v []any) []byte {
+	for _, a := range v {
+		err, ok := a.(error)
+		if !ok {
+			continue
+		}
+
+		for err = errors.Unwrap(err); err != nil; err = errors.Unwrap(err) {
+			b = append(b, "\n\t"...)
+			b = append(b, err.Error()...)
+		}
+	}
+	return b
+}
+
+// render the prefix, host, pid, file location and message of 'e'
+func appendBody(b []byte, e *Event) []byte {
+	b = append(b, e.Prefix...)
+
+	if (e.Flags & (Lhostname | Lpid)) != 0 {
+		if (e.Flags & Lhostname) != 0 {
+			b = append(b, hostname()...)
+		}
+		if (e.Flags & Lpid) != 0 {
+			b = append(b, '[')
+			b = strconv.AppendInt(b, int64(pid), 10)
+			b = append(b, ']')
+		}
+		b = append(b, ' ')
+	}
+
+	if len(e.File) > 0 {
+		cols := (e.Flags & Lcolumns) != 0
+		switch {
+		case cols && len(e.Func) > 0:
+			b = fmt.Appendf(b, "%s:%d %s ", e.File, e.Line, e.Func)
+		case cols:
+			b = fmt.Appendf(b, "%s:%d ", e.File, e.Line)
+		case len(e.Func) > 0:
+			b = fmt.Appendf(b, "(%s:%d %s) ", e.File, e.Line, e.Func)
+		default:
+			b = fmt.Appendf(b, "(%s:%d) ", e.File, e.Line)
+		}
+	}
+	return append(b, e.Msg...)
+}
+
+// vim: ft=go:sw=8:ts=8:noexpandtab:tw=98:
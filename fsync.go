@@ -0,0 +1,143 @@
+// fsync.go - fsync policy of log files
+//
+// Copyright 2012, Sudhi Herle <sudhi -at- herle.net>
+// This code is licensed under the same terms as the golang core.
+
+package logger
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// SyncEvery sets how log files are synced to disk. The default (d <= 0)
+// opens the log file with O_SYNC: every write is durable before the next
+// one is made, at a significant cost in throughput. A positive 'd'
+// reopens the log file without O_SYNC and fsyncs it every 'd' (and when
+// the logger is closed) if it was written to since the last fsync; a
+// crash can then lose upto 'd' worth of log lines. The setting is shared
+// by all sub-loggers.
+func (l *xLogger) SyncEvery(d time.Duration) error {
+	// the log file belongs to the root logger
+	l = l.ch.root
+	if len(l.name) == 0 {
+		return fmt.Errorf("%s: logger is not file backed", l.Prefix())
+	}
+
+	if d < 0 {
+		d = 0
+	}
+	l.ch.swait.Store(int64(d))
+	if !l.qevent(qev{ty: _QEV_FSYNC}) {
+		return fmt.Errorf("%s: logger is closed", l.Prefix())
+	}
+	return nil
+}
+
+// Sync writes the queued log lines - like Flush() - and fsyncs the log
+// file: when it returns nil, the log lines are durable on disk. For a
+// logger that isn't file backed, it only flushes.
+func (l *xLogger) Sync() error {
+	done := make(chan error, 1)
+	if !l.qevent(qev{ty: _QEV_SYNC, done: done}) {
+		return fmt.Errorf("%s: logger is closed", l.Prefix())
+	}
+	return <-done
+}
+
+// return the flags to open log files with as per the fsync policy
+func (l *xLogger) syncFlag() int {
+	if l.ch.swait.Load() > 0 {
+		return 0
+	}
+	return os.O_SYNC
+}
+
+// return the channel that fires when the log file must be synced
+func (l *xLogger) syncTick() <-chan time.Time {
+	if l.ch.fsync == nil {
+		return nil
+	}
+	return l.ch.fsync.C
+}
+
+// apply the current fsync policy: the log file is reopened with or
+// without O_SYNC and the periodic fsync is restarted. Called only from
+// the writer.
+func (l *xLogger) resetSync() {
+	if l.ch.fsync != nil {
+		l.ch.fsync.Stop()
+		l.ch.fsync = nil
+	}
+
+	d := time.Duration(l.ch.swait.Load())
+	if d > 0 {
+		l.ch.fsync = time.NewTicker(d)
+	}
+
+	l.mu.Lock()
+	isfile := (l.flag & lClose) != 0
+	l.mu.Unlock()
+
+	fd, ok := l.out.(*os.File)
+	if !isfile || !ok {
+		return
+	}
+
+	nfd, err := os.OpenFile(l.name, os.O_WRONLY|os.O_CREATE|os.O_APPEND|l.syncFlag(), 0600)
+	if err != nil {
+		l.ioError(fmt.Errorf("logger: %s reopen: %w", l.name, err))
+		return
+	}
+
+	l.fsync()
+	fd.Close()
+	l.setOut(nfd)
+}
+
+// stop the periodic fsync and sync pending writes. Called only from the
+// writer.
+func (l *xLogger) stopSync() {
+	if l.ch.fsync != nil {
+		l.ch.fsync.Stop()
+		l.ch.fsync = nil
+		l.fsync()
+	}
+}
+
+// fsync the log file if it was written since the last fsync. Called
+// only from the writer.
+func (l *xLogger) fsync() {
+	if !l.ch.unsynced {
+		return
+	}
+	l.ch.unsynced = false
+
+	if fd, ok := l.out.(*os.File); ok {
+		if err := fd.Sync(); err != nil {
+			l.ioError(fmt.Errorf("logger: %s fsync: %w", l.name, err))
+		}
+	}
+}
+
+// fsync the log file - if the logger is file backed. Called only from
+// the writer.
+func (l *xLogger) syncFile() error {
+	l.mu.Lock()
+	isfile := (l.flag & lClose) != 0
+	l.mu.Unlock()
+
+	fd, ok := l.out.(*os.File)
+	if !isfile || !ok {
+		return nil
+	}
+
+	l.ch.unsynced = false
+	if err := fd.Sync(); err != nil {
+		return fmt.Errorf("logger: %s fsync: %w", l.name, err)
+	}
+	return nil
+}
+
+// vim: ft=go:sw=8:ts=8:noexpandtab:tw=98:
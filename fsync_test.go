@@ -0,0 +1,104 @@
+package logger
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyncEvery(t *testing.T) {
+	assert := newAsserter(t, "syncevery")
+	fn := filepath.Join(t.TempDir(), "app.log")
+
+	ll, err := NewFilelog(fn, LOG_INFO, "", 0)
+	assert(err == nil, "can't create log: %s", err)
+
+	x := ll.(*xLogger)
+	ll.Info("before")
+	err = x.SyncEvery(10 * time.Millisecond)
+	assert(err == nil, "sync every: %s", err)
+
+	ll.Info("periodic")
+	time.Sleep(30 * time.Millisecond)
+
+	err = x.SyncEvery(0)
+	assert(err == nil, "sync every: %s", err)
+	ll.Info("after")
+	ll.Close()
+
+	b, err := os.ReadFile(fn)
+	assert(err == nil, "read: %s", err)
+
+	s := string(b)
+	for _, m := range []string{"before\n", "periodic\n", "after\n"} {
+		assert(strings.Contains(s, m), "missing %q:\n%s", m, s)
+	}
+	assert(strings.Index(s, "before") < strings.Index(s, "periodic"), "out of order:\n%s", s)
+
+	nl, err := New(os.Stderr, LOG_INFO, "", 0)
+	assert(err == nil, "can't create log: %s", err)
+	defer nl.Close()
+
+	err = nl.(*xLogger).SyncEvery(time.Second)
+	assert(err != nil, "expected error for a non-file logger")
+}
+
+func TestSync(t *testing.T) {
+	assert := newAsserter(t, "sync")
+	fn := filepath.Join(t.TempDir(), "app.log")
+
+	ll, err := NewFilelog(fn, LOG_INFO, "", 0)
+	assert(err == nil, "can't create log: %s", err)
+	defer ll.Close()
+
+	err = ll.(*xLogger).SyncEvery(time.Hour)
+	assert(err == nil, "sync every: %s", err)
+
+	for i := 0; i < 10; i++ {
+		ll.Info("line %d", i)
+	}
+	err = ll.Sync()
+	assert(err == nil, "sync: %s", err)
+
+	// the lines must be visible via a fresh open
+	fd, err := os.Open(fn)
+	assert(err == nil, "open: %s", err)
+	b, err := io.ReadAll(fd)
+	fd.Close()
+	assert(err == nil, "read: %s", err)
+	assert(strings.Contains(string(b), "line 9\n"), "missing line:\n%s", b)
+
+	nl, err := New(os.Stderr, LOG_INFO, "", 0)
+	assert(err == nil, "can't create log: %s", err)
+
+	err = nl.(RotatableLogger).Sync()
+	assert(err == nil, "sync of a non-file logger: %s", err)
+	nl.Close()
+
+	err = nl.(RotatableLogger).Sync()
+	assert(err != nil, "sync of a closed logger")
+}
+
+func benchmarkSync(b *testing.B, d time.Duration) {
+	fn := filepath.Join(b.TempDir(), "bench.log")
+	ll, err := NewFilelog(fn, LOG_INFO, "", 0)
+	if err != nil {
+		b.Fatalf("can't create log: %s", err)
+	}
+
+	if d > 0 {
+		ll.(*xLogger).SyncEvery(d)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ll.Info("benchmark line %d with some text", i)
+	}
+	ll.Close()
+}
+
+func BenchmarkSyncWrite(b *testing.B)    { benchmarkSync(b, 0) }
+func BenchmarkSyncPeriodic(b *testing.B) { benchmarkSync(b, time.Second) }
@@ -0,0 +1,203 @@
+// gelf.go - GELF (Graylog Extended Log Format) output over UDP
+//
+// Copyright 2012, Sudhi Herle <sudhi -at- herle.net>
+// This code is licensed under the same terms as the golang core.
+
+package logger
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"unicode/utf8"
+)
+
+const (
+	// Max length of the GELF short_message; longer messages are
+	// truncated early so that most datagrams fit in a single
+	// (uncompressed, unchunked) GELF UDP packet.
+	_GELF_MAXMSG = 7680
+
+	// Max size of an encoded GELF message: the payload of a single UDP
+	// packet that Graylog accepts without chunking.
+	_GELF_MAXPKT = 8192
+
+	// Min encoded length of the short_message; if the additional fields
+	// leave less room than this, they are dropped.
+	_GELF_MINMSG = 256
+)
+
+// NewGELF creates a new logger instance at the given priority that sends
+// each log message as a GELF 1.1 JSON object in a UDP datagram to the
+// Graylog server at 'addr' (host:port). The prefix appears at the
+// beginning of the short_message; the level is the syslog severity of
+// the message priority and fields attached via WithFields() are sent as
+// additional fields ("_key").
+//
+// Messages are neither compressed nor chunked: each datagram is at most
+// 8192 bytes. A short_message longer than 7680 bytes is truncated and if
+// the encoded message still doesn't fit, the short_message is trimmed
+// further; if the additional fields don't leave room for it, they are
+// dropped.
+func NewGELF(addr string, prio Priority, prefix string) (Logger, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("gelf: hostname: %w", err)
+	}
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("gelf: %s: %w", addr, err)
+	}
+
+	ll := makeLogger(conn, prio, prefix, defaultFlag(0)|lClose, nil)
+	ll.fmtr = &gelfFormatter{host}
+	ll.maxlen = _GELF_MAXMSG
+	ll.run()
+	return ll, nil
+}
+
+// gelfFormatter renders GELF 1.1 messages from 'host'
+type gelfFormatter struct {
+	host string
+}
+
+func (g *gelfFormatter) Format(b []byte, e Event) []byte {
+	// GELF requires a short_message
+	if len(e.Msg) == 0 {
+		return b
+	}
+
+	msg := string(appendBody(nil, &e))
+	start := len(b)
+
+	b = g.append(b, &e, msg, _GELF_MAXPKT, true)
+	if len(b)-start <= _GELF_MAXPKT {
+		return b
+	}
+
+	// everything but the short_message must fit with room to spare;
+	// an empty short_message encodes as 2 bytes.
+	fields := true
+	room := _GELF_MAXPKT - (len(g.append(b[:start], &e, "", 2, fields)) - start - 2)
+	if room < _GELF_MINMSG {
+		fields = false
+		room = _GELF_MAXPKT - (len(g.append(b[:start], &e, "", 2, fields)) - start - 2)
+	}
+	return g.append(b[:start], &e, msg, room, fields)
+}
+
+// append the GELF message for 'e' with 'msg' as the short_message of at
+// most 'max' encoded bytes; the additional fields are added if 'fields'
+// is set.
+func (g *gelfFormatter) append(b []byte, e *Event, msg string, max int, fields bool) []byte {
+	t := e.Time
+	b = append(b, `{"version":"1.1","host":`...)
+	b = appendJSONString(b, g.host)
+	b = append(b, `,"short_message":`...)
+	b = appendJSONStringMax(b, msg, max)
+	b = fmt.Appendf(b, `,"timestamp":%d.%06d`, t.Unix(), t.Nanosecond()/1000)
+	b = append(b, `,"level":`...)
+	b = strconv.AppendInt(b, int64(prioSeverity[e.Prio]), 10)
+
+	if fields {
+		for i := range e.Fields {
+			f := &e.Fields[i]
+			b = append(b, ',')
+			b = appendJSONString(b, "_"+f.Key)
+			b = append(b, ':')
+			b = appendGELFValue(b, f.Value)
+		}
+	}
+	return append(b, '}')
+}
+
+// GELF additional fields are either numbers or strings
+func appendGELFValue(b []byte, v any) []byte {
+	switch x := v.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return fmt.Appendf(b, "%d", x)
+	case float32:
+		return strconv.AppendFloat(b, float64(x), 'g', -1, 32)
+	case float64:
+		return strconv.AppendFloat(b, x, 'g', -1, 64)
+	default:
+		return appendJSONString(b, fmt.Sprint(v))
+	}
+}
+
+// append 's' as a quoted JSON string
+func appendJSONString(b []byte, s string) []byte {
+	const hex = "0123456789abcdef"
+
+	b = append(b, '"')
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c >= utf8.RuneSelf {
+			r, n := utf8.DecodeRuneInString(s[i:])
+			if r == utf8.RuneError && n == 1 {
+				b = append(b, "\ufffd"...)
+			} else {
+				b = append(b, s[i:i+n]...)
+			}
+			i += n
+			continue
+		}
+
+		switch c {
+		case '"', '\\':
+			b = append(b, '\\', c)
+		case '\n':
+			b = append(b, '\\', 'n')
+		case '\r':
+			b = append(b, '\\', 'r')
+		case '\t':
+			b = append(b, '\\', 't')
+		default:
+			if c < 0x20 {
+				b = append(b, '\\', 'u', '0', '0', hex[c>>4], hex[c&0xf])
+			} else {
+				b = append(b, c)
+			}
+		}
+		i++
+	}
+	return append(b, '"')
+}
+
+// append 's' as a quoted JSON string of at most 'max' bytes; a longer
+// string is cut at a character boundary and ends in "...".
+func appendJSONStringMax(b []byte, s string, max int) []byte {
+	start := len(b)
+	if b = appendJSONString(b, s); len(b)-start <= max {
+		return b
+	}
+
+	// the quotes and the marker
+	n, w := 0, 5
+	for n < len(s) {
+		c := s[n]
+		r, sz := utf8.DecodeRuneInString(s[n:])
+
+		x := sz
+		switch {
+		case r == utf8.RuneError && sz == 1:
+			x = len("\ufffd")
+		case c == '"' || c == '\\' || c == '\n' || c == '\r' || c == '\t':
+			x = 2
+		case c < 0x20:
+			x = 6
+		}
+		if w+x > max {
+			break
+		}
+		w += x
+		n += sz
+	}
+
+	b = appendJSONString(b[:start], s[:n])
+	return append(b[:len(b)-1], `..."`...)
+}
+
+// vim: ft=go:sw=8:ts=8:noexpandtab:tw=98:
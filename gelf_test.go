@@ -0,0 +1,127 @@
+package logger
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGELF(t *testing.T) {
+	assert := newAsserter(t, "gelf")
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert(err == nil, "listen: %s", err)
+	defer pc.Close()
+
+	ll, err := NewGELF(pc.LocalAddr().String(), LOG_INFO, "web")
+	assert(err == nil, "can't create log: %s", err)
+
+	ll.Info("hello \"world\"")
+	ll.WithFields(map[string]any{"user": "bob", "n": 3}).Error("oops")
+	ll.Debug("not sent")
+	ll.Info("")
+	ll.(*xLogger).SetDropEmpty(false)
+	ll.Info("")
+	ll.Close()
+
+	recv := func() map[string]any {
+		var buf [8192]byte
+
+		pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := pc.ReadFrom(buf[:])
+		assert(err == nil, "read: %s", err)
+
+		var m map[string]any
+		err = json.Unmarshal(buf[:n], &m)
+		assert(err == nil, "json: %s\n%s", err, buf[:n])
+		return m
+	}
+
+	// skip the banner
+	m := recv()
+	assert(strings.Contains(m["short_message"].(string), "started"), "banner: %v", m)
+
+	now := float64(time.Now().Unix())
+	m = recv()
+	assert(m["version"] == "1.1", "version: %v", m["version"])
+	assert(len(m["host"].(string)) > 0, "empty host")
+	assert(m["short_message"] == `[web] hello "world"`, "msg: %v", m["short_message"])
+	assert(m["level"] == float64(6), "level: %v", m["level"])
+	ts := m["timestamp"].(float64)
+	assert(ts > now-60 && ts < now+60, "timestamp: %v", ts)
+
+	m = recv()
+	assert(m["short_message"] == "[web] oops", "msg: %v", m["short_message"])
+	assert(m["level"] == float64(3), "level: %v", m["level"])
+	assert(m["_user"] == "bob", "user: %v", m["_user"])
+	assert(m["_n"] == float64(3), "n: %v", m["_n"])
+
+	// the close message is next - not the debug line or the empty
+	// messages
+	m = recv()
+	assert(strings.Contains(m["short_message"].(string), "closed"), "close: %v", m)
+}
+
+func TestGELFSize(t *testing.T) {
+	assert := newAsserter(t, "gelf-size")
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert(err == nil, "listen: %s", err)
+	defer pc.Close()
+
+	ll, err := NewGELF(pc.LocalAddr().String(), LOG_INFO, "web")
+	assert(err == nil, "can't create log: %s", err)
+
+	long := strings.Repeat("x", 3000)
+	ll.WithFields(map[string]any{"a": long, "b": long}).Info("%s", strings.Repeat("m", 7000))
+	ll.Info("%s", strings.Repeat("\x01", 7000))
+	ll.WithFields(map[string]any{"huge": strings.Repeat("y", 9000)}).Info("big fields")
+	ll.Close()
+
+	recv := func() map[string]any {
+		var buf [65536]byte
+
+		pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := pc.ReadFrom(buf[:])
+		assert(err == nil, "read: %s", err)
+		assert(n <= _GELF_MAXPKT, "datagram of %d bytes", n)
+
+		var m map[string]any
+		err = json.Unmarshal(buf[:n], &m)
+		assert(err == nil, "json: %s\n%s", err, buf[:n])
+		return m
+	}
+
+	// skip the banner
+	recv()
+
+	m := recv()
+	assert(m["_a"] == long && m["_b"] == long, "fields not sent: %v", m)
+	msg := m["short_message"].(string)
+	assert(strings.HasPrefix(msg, "[web] mmm") && strings.HasSuffix(msg, "m..."), "msg: %.40q", msg)
+
+	m = recv()
+	msg = m["short_message"].(string)
+	assert(strings.HasPrefix(msg, "[web] \x01") && strings.HasSuffix(msg, "\x01..."), "msg: %.40q", msg)
+
+	m = recv()
+	assert(m["short_message"] == "[web] big fields", "msg: %v", m["short_message"])
+	_, ok := m["_huge"]
+	assert(!ok, "oversized field sent")
+}
+
+func TestJSONString(t *testing.T) {
+	assert := newAsserter(t, "jsonstr")
+
+	tests := []string{"plain", `q"b\s`, "nl\n\ttab", "ctl\x01", "héllo", "bad\xff"}
+	for _, s := range tests {
+		b := appendJSONString(nil, s)
+
+		var x string
+		err := json.Unmarshal(b, &x)
+		assert(err == nil, "%q: %s", b, err)
+		assert(x == strings.ToValidUTF8(s, "�"), "exp %q, saw %q", s, x)
+	}
+}
@@ -0,0 +1,20 @@
+// isatty_bsd.go - terminal detection for darwin and the BSDs
+//
+// Copyright 2012, Sudhi Herle <sudhi -at- herle.net>
+// This code is licensed under the same terms as the golang core.
+
+//go:build darwin || freebsd || netbsd || openbsd || dragonfly
+
+package logger
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+func isatty(fd uintptr) bool {
+	var t syscall.Termios
+
+	_, _, err := syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TIOCGETA, uintptr(unsafe.Pointer(&t)))
+	return err == 0
+}
@@ -0,0 +1,18 @@
+// isatty_linux.go - terminal detection for linux
+//
+// Copyright 2012, Sudhi Herle <sudhi -at- herle.net>
+// This code is licensed under the same terms as the golang core.
+
+package logger
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+func isatty(fd uintptr) bool {
+	var t syscall.Termios
+
+	_, _, err := syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TCGETS, uintptr(unsafe.Pointer(&t)))
+	return err == 0
+}
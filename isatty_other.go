@@ -0,0 +1,13 @@
+// isatty_other.go - terminal detection for unsupported platforms
+//
+// Copyright 2012, Sudhi Herle <sudhi -at- herle.net>
+// This code is licensed under the same terms as the golang core.
+
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+
+package logger
+
+// we don't know how to detect a terminal; colors must be forced.
+func isatty(fd uintptr) bool {
+	return false
+}
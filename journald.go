@@ -0,0 +1,99 @@
+// journald.go - systemd journal native protocol output
+//
+// Copyright 2012, Sudhi Herle <sudhi -at- herle.net>
+// This code is licensed under the same terms as the golang core.
+
+//go:build unix
+
+package logger
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// path to the journald native protocol socket
+var journalSocket = "/run/systemd/journal/socket"
+
+// NewJournald creates a new logger instance at the given priority that
+// sends each log message to the systemd journal using its native
+// protocol. Each entry carries MESSAGE, PRIORITY (the syslog severity of
+// the message priority) and SYSLOG_IDENTIFIER (the program name); fields
+// attached via WithFields() are sent as additional journal fields with
+// their names uppercased. The prefix appears at the beginning of MESSAGE.
+//
+// NewJournald returns an error on hosts that don't run systemd-journald.
+func NewJournald(prio Priority, prefix string) (Logger, error) {
+	if _, err := os.Stat(journalSocket); err != nil {
+		return nil, fmt.Errorf("journald: %s: not a systemd host? %w", journalSocket, err)
+	}
+
+	conn, err := net.Dial("unixgram", journalSocket)
+	if err != nil {
+		return nil, fmt.Errorf("journald: %s: %w", journalSocket, err)
+	}
+
+	ll := makeLogger(conn, prio, prefix, defaultFlag(0)|lClose, nil)
+	ll.fmtr = &journalFormatter{path.Base(os.Args[0])}
+	ll.run()
+	return ll, nil
+}
+
+// journalFormatter renders journal entries for program 'ident'
+type journalFormatter struct {
+	ident string
+}
+
+func (j *journalFormatter) Format(b []byte, e Event) []byte {
+	// an entry without a message is useless
+	if len(e.Msg) == 0 {
+		return b
+	}
+
+	b = appendJournalField(b, "MESSAGE", string(appendBody(nil, &e)))
+	b = appendJournalField(b, "PRIORITY", strconv.Itoa(prioSeverity[e.Prio]))
+	b = appendJournalField(b, "SYSLOG_IDENTIFIER", j.ident)
+	for i := range e.Fields {
+		f := &e.Fields[i]
+		b = appendJournalField(b, journalName(f.Key), fmt.Sprint(f.Value))
+	}
+	return b
+}
+
+// append a journal field; values with newlines use the binary form:
+// NAME\n<little endian uint64 length>value\n
+func appendJournalField(b []byte, name, val string) []byte {
+	b = append(b, name...)
+	if strings.IndexByte(val, '\n') < 0 {
+		b = append(b, '=')
+	} else {
+		b = append(b, '\n')
+		b = binary.LittleEndian.AppendUint64(b, uint64(len(val)))
+	}
+	b = append(b, val...)
+	return append(b, '\n')
+}
+
+// journal field names are uppercase letters, digits and underscores
+// and must not start with a digit or an underscore.
+func journalName(s string) string {
+	b := []byte(strings.ToUpper(s))
+	for i, c := range b {
+		if !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') {
+			b[i] = '_'
+		}
+	}
+
+	s = strings.TrimLeft(string(b), "_")
+	if len(s) == 0 || (s[0] >= '0' && s[0] <= '9') {
+		s = "F_" + s
+	}
+	return s
+}
+
+// vim: ft=go:sw=8:ts=8:noexpandtab:tw=98:
@@ -0,0 +1,71 @@
+//go:build unix
+
+package logger
+
+import (
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJournald(t *testing.T) {
+	assert := newAsserter(t, "journald")
+
+	sock := filepath.Join(t.TempDir(), "journal.sock")
+	pc, err := net.ListenPacket("unixgram", sock)
+	assert(err == nil, "listen: %s", err)
+	defer pc.Close()
+
+	old := journalSocket
+	journalSocket = sock
+	defer func() { journalSocket = old }()
+
+	ll, err := NewJournald(LOG_INFO, "web")
+	assert(err == nil, "can't create log: %s", err)
+
+	ll.WithFields(map[string]any{"req-id": 42}).Warn("slow\nrequest")
+	ll.Info("")
+	ll.(*xLogger).SetDropEmpty(false)
+	ll.Info("")
+	ll.Close()
+
+	recv := func() string {
+		var buf [8192]byte
+
+		pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := pc.ReadFrom(buf[:])
+		assert(err == nil, "read: %s", err)
+		return string(buf[:n])
+	}
+
+	// skip the banner
+	s := recv()
+	assert(strings.Contains(s, "PRIORITY=6\n"), "banner priority:\n%q", s)
+
+	s = recv()
+	assert(strings.Contains(s, "PRIORITY=4\n"), "warn priority:\n%q", s)
+	assert(strings.Contains(s, "SYSLOG_IDENTIFIER="), "missing identifier:\n%q", s)
+	assert(strings.Contains(s, "REQ_ID=42\n"), "missing field:\n%q", s)
+
+	msg := "[web] slow\nrequest"
+	exp := "MESSAGE\n" + string([]byte{byte(len(msg)), 0, 0, 0, 0, 0, 0, 0}) + msg + "\n"
+	assert(strings.HasPrefix(s, exp), "binary message:\n%q", s)
+
+	// the empty messages aren't sent
+	s = recv()
+	assert(strings.Contains(s, "closed"), "exp close message:\n%q", s)
+}
+
+func TestJournaldMissing(t *testing.T) {
+	assert := newAsserter(t, "journald-missing")
+
+	old := journalSocket
+	journalSocket = filepath.Join(t.TempDir(), "nonexistent")
+	defer func() { journalSocket = old }()
+
+	_, err := NewJournald(LOG_INFO, "web")
+	assert(err != nil, "expected error without a journal socket")
+	assert(strings.Contains(err.Error(), "systemd"), "unclear error: %s", err)
+}
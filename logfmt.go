@@ -0,0 +1,66 @@
+// logfmt.go - logfmt output
+//
+// Copyright 2012, Sudhi Herle <sudhi -at- herle.net>
+// This code is licensed under the same terms as the golang core.
+
+package logger
+
+import (
+	"strconv"
+	"strings"
+)
+
+// LogfmtFormatter renders log events as a line of space separated
+// key=value pairs (logfmt):
+//
+//	ts=2006-01-02T15:04:05.000Z level=info prefix=foo msg="hello world" file=x.go:12
+//
+// The file location is rendered only if Lfileloc is set and host and pid
+// only if Lhostname and Lpid are set. Fields attached via WithFields()
+// follow as additional pairs. Values that are empty or contain spaces,
+// quotes or '=' are quoted.
+type LogfmtFormatter struct{}
+
+var _ Formatter = LogfmtFormatter{}
+
+// Format renders 'e' as a logfmt line
+func (LogfmtFormatter) Format(b []byte, e Event) []byte {
+	b = append(b, "ts="...)
+	b = e.Time.AppendFormat(b, "2006-01-02T15:04:05.000Z07:00")
+	b = append(b, " level="...)
+	b = append(b, strings.ToLower(e.Prio.String())...)
+
+	if len(e.Prefix) > 0 {
+		b = append(b, " prefix="...)
+		b = appendValue(b, barePrefix(e.Prefix))
+	}
+	if (e.Flags & Lhostname) != 0 {
+		b = append(b, " host="...)
+		b = appendValue(b, hostname())
+	}
+	if (e.Flags & Lpid) != 0 {
+		b = append(b, " pid="...)
+		b = strconv.AppendInt(b, int64(pid), 10)
+	}
+
+	b = append(b, " msg="...)
+	b = appendValue(b, string(e.Msg))
+
+	if len(e.File) > 0 {
+		b = append(b, " file="...)
+		b = appendValue(b, e.File+":"+strconv.Itoa(e.Line))
+		if len(e.Func) > 0 {
+			b = append(b, " func="...)
+			b = appendValue(b, e.Func)
+		}
+	}
+
+	if len(e.fldstr) > 0 {
+		b = append(b, e.fldstr...)
+	} else {
+		b = appendFields(b, e.Fields)
+	}
+	return append(b, '\n')
+}
+
+// vim: ft=go:sw=8:ts=8:noexpandtab:tw=98:
@@ -0,0 +1,31 @@
+package logger
+
+import (
+	"bytes"
+	re "regexp"
+	"strings"
+	"testing"
+)
+
+func TestLogfmt(t *testing.T) {
+	assert := newAsserter(t, "logfmt")
+
+	var b bytes.Buffer
+	ll, err := New(&b, LOG_DEBUG, "foo", Ldate|Ltime|Lfileloc)
+	assert(err == nil, "can't create log: %s", err)
+
+	ll.(*xLogger).SetFormatter(LogfmtFormatter{})
+	ll.Info("hello world")
+	ll.WithFields(map[string]any{"user": "bob", "q": "a=b"}).Error("oops")
+	ll.Close()
+
+	lines := strings.Split(b.String(), "\n")
+	assert(len(lines) == 5, "exp 4 lines; saw:\n%s", b.String())
+
+	ts := `^ts=\d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{3}Z `
+	rx := re.MustCompile(ts + `level=info prefix=foo msg="hello world" file=logfmt_test.go:\d+$`)
+	assert(rx.MatchString(lines[1]), "info line mismatch: %q", lines[1])
+
+	rx = re.MustCompile(ts + `level=error prefix=foo msg=oops file=logfmt_test.go:\d+ q="a=b" user=bob$`)
+	assert(rx.MatchString(lines[2]), "error line mismatch: %q", lines[2])
+}
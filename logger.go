@@ -24,12 +24,14 @@
 //
 //   - The time is by default logged at millisecond resolution;
 //     the flag `Lmicroseconds` causes timestamps to be printed in
-//     microsecond resolution.
+//     microsecond resolution and `Lnanoseconds` in nanosecond resolution.
 //
 //   - A Logger instance can log relative timestamps with the flag
 //     `Lreltime`. Relative timestamps are always logged at the full resolution
 //     of the available OS time source (Nanoseconds on major platforms).
 //     Use of `Lreltime` supercedes `Ldate` and `Ltime`.
+//     The flag `Lrelseconds` prints them as decimal seconds (`+3723.456789123`)
+//     instead of a duration string (`+1h2m3.456789123s`).
 //
 //   - *NB*: when `Lreltime` is in effect, the very first log
 //     message will have a full timestamp and *NOT* the relative timestamp.
@@ -42,9 +44,11 @@
 //
 //     LOG_DEBUG
 //     LOG_INFO
+//     LOG_NOTICE
 //     LOG_WARN
 //     LOG_ERR
 //     LOG_CRIT
+//     LOG_ALERT
 //     LOG_EMERG
 //
 //   - An instance of a logger is configured with a given log level;
@@ -61,11 +65,12 @@
 //     considered when backtraces are printed.
 //
 //   - The `Panic()` and `Fatal()` logger methods implicitly print the
-//     stack backtrace (upto 5 levels).
+//     stack backtrace (upto 6 levels by default; see `SetPanicDepth()`).
 //
-//   - `DEBUG, ERR, CRIT` log outputs (via `Debug(), Err() and Crit()`
-//     methods) also print the source file location from whence they
-//     were invoked. `Lfullpath` flag is honored for the backtrace.
+//   - When `Lfileloc` is set, all log outputs also print the source file
+//     location from whence they were invoked. `Lfullpath` flag is honored
+//     for the backtrace.
+//     The `Lfunc` flag additionally prints the name of the calling function.
 //
 //   - A Logger instance can be turned into a stdlib's Logger via the
 //     `Logger.StdLogger()` method.
@@ -79,8 +84,11 @@
 //     priority and prefix (but same destination); this is useful in large
 //     programs with different modules.
 //
-//   - Compressed log rotation based on daily ToD (configurable ToD) -- only
-//     available for file-backed destinations.
+//   - `WithFields()` creates a child-logger that appends a fixed set of
+//     `key=value` pairs to every log line.
+//
+//   - Compressed log rotation based on hourly, daily or weekly ToD
+//     (configurable ToD) -- only available for file-backed destinations.
 package logger
 
 import (
@@ -99,6 +107,7 @@ import (
 	"sync"
 	"sync/atomic"
 	"time"
+	"unicode/utf8"
 )
 
 // These flags define which text to prefix to each log entry generated by the Logger.
@@ -109,10 +118,21 @@ const (
 	//  2009/01/23 01:23:23.123123 /a/b/c/d.go:23: message
 	Ldate         = 1 << iota // the date: 2009/01/23
 	Ltime                     // the time: 01:23:23
-	Lmicroseconds             // microsecond resolution: 01:23:23.123123.  assumes Ltime.
+	Lmicroseconds             // microsecond resolution: 01:23:23.123123.  implies Ltime.
 	Lfileloc                  // put file name and line number in the log
 	Lfullpath                 // full file path and line number: /a/b/c/d.go:23
 	Lreltime                  // print relative time from start of program
+	Lfunc                     // put the calling function name next to the file location
+	Lcolor                    // colorize the log level when writing to a terminal (unless NO_COLOR is set)
+	Lforcecolor               // colorize the log level regardless of the destination
+	Llevelname                // print the log level by name (INFO:) instead of number (<2>:)
+	Lseqno                    // prepend a sequence number (in the order of writes) to each line
+	Lhostname                 // put the host name after the prefix: myhost
+	Lpid                      // put the process id after the prefix (and host name): myhost[1234]
+	Lnanoseconds              // nanosecond resolution: 01:23:23.123123123. implies Ltime; supersedes Lmicroseconds
+	Lrelseconds               // print relative time in decimal seconds: +3723.456789123. implies Lreltime
+	Lerrchain                 // append the chain of wrapped errors of each error argument on its own line
+	Lcolumns                  // separate the header fields by a space: <6>: 2009/01/23 01:23:23.123 [prefix] d.go:23 message
 
 	// Internal flags
 	lSyslog // set to indicate that output destination is syslog; Ldate|Ltime|Lmicroseconds are ignored
@@ -120,6 +140,9 @@ const (
 	lClose  // close the file when done
 	lSublog // Set if this is a sub-logger
 	lRotate // Rotate the logs
+	lColor  // set if Lcolor or Lforcecolor is in effect for this destination
+
+	lInternal = lSyslog | lPrefix | lClose | lSublog | lRotate
 
 	Lstdflag = Ldate | Ltime // initial values for the standard logger
 )
@@ -128,9 +151,11 @@ const (
 //
 //	LOG_DEBUG
 //	LOG_INFO
+//	LOG_NOTICE
 //	LOG_WARN
 //	LOG_ERR
 //	LOG_CRIT
+//	LOG_ALERT
 //	LOG_EMERG
 //
 // An instance of a logger is configured with a given log level;
@@ -142,8 +167,18 @@ const (
 	_MAX_LOGFILES     = 7
 	_PANIC_BACKTRACES = 6
 
+	// Max number of frames in a backtrace
+	_MAX_BACKTRACE = 64
+
 	// line length of a log buffer
 	_LOGBUFSZ = 256
+
+	// log buffers that grew beyond this multiple of the buffer size
+	// aren't returned to the pool
+	_LOGBUFGROW = 16
+
+	// max size of a batch of coalesced log lines
+	_BATCHMAX = 64 * 1024
 )
 
 // Log Priorities
@@ -151,9 +186,11 @@ const (
 	LOG_NONE Priority = iota
 	LOG_DEBUG
 	LOG_INFO
+	LOG_NOTICE
 	LOG_WARN
 	LOG_ERR
 	LOG_CRIT
+	LOG_ALERT
 	LOG_EMERG
 
 	// keep in the end
@@ -164,23 +201,27 @@ const (
 // levels defined in config files and turning them into usable
 // priorities.
 var prioName = map[string]Priority{
-	"LOG_DEBUG": LOG_DEBUG,
-	"LOG_INFO":  LOG_INFO,
-	"LOG_WARN":  LOG_WARN,
-	"LOG_ERR":   LOG_ERR,
-	"LOG_ERROR": LOG_ERR,
-	"LOG_CRIT":  LOG_CRIT,
-	"LOG_EMERG": LOG_EMERG,
-	"LOG_NONE":  LOG_NONE,
+	"LOG_DEBUG":  LOG_DEBUG,
+	"LOG_INFO":   LOG_INFO,
+	"LOG_NOTICE": LOG_NOTICE,
+	"LOG_WARN":   LOG_WARN,
+	"LOG_ERR":    LOG_ERR,
+	"LOG_ERROR":  LOG_ERR,
+	"LOG_CRIT":   LOG_CRIT,
+	"LOG_ALERT":  LOG_ALERT,
+	"LOG_EMERG":  LOG_EMERG,
+	"LOG_NONE":   LOG_NONE,
 
 	"DEBUG":     LOG_DEBUG,
 	"INFO":      LOG_INFO,
+	"NOTICE":    LOG_NOTICE,
 	"WARNING":   LOG_WARN,
 	"WARN":      LOG_WARN,
 	"ERR":       LOG_ERR,
 	"ERROR":     LOG_ERR,
 	"CRIT":      LOG_CRIT,
 	"CRITICAL":  LOG_CRIT,
+	"ALERT":     LOG_ALERT,
 	"EMERG":     LOG_EMERG,
 	"EMERGENCY": LOG_EMERG,
 	"NONE":      LOG_NONE,
@@ -188,13 +229,27 @@ var prioName = map[string]Priority{
 
 // Map log priorities to their string names
 var prioString = map[Priority]string{
-	LOG_DEBUG: "DEBUG",
-	LOG_INFO:  "INFO",
-	LOG_WARN:  "WARNING",
-	LOG_ERR:   "ERROR",
-	LOG_CRIT:  "CRITICAL",
-	LOG_EMERG: "EMERGENCY",
-	LOG_NONE:  "NONE",
+	LOG_DEBUG:  "DEBUG",
+	LOG_INFO:   "INFO",
+	LOG_NOTICE: "NOTICE",
+	LOG_WARN:   "WARNING",
+	LOG_ERR:    "ERROR",
+	LOG_CRIT:   "CRITICAL",
+	LOG_ALERT:  "ALERT",
+	LOG_EMERG:  "EMERGENCY",
+	LOG_NONE:   "NONE",
+}
+
+// Map log priorities to syslog(3) severities
+var prioSeverity = map[Priority]int{
+	LOG_DEBUG:  7,
+	LOG_INFO:   6,
+	LOG_NOTICE: 5,
+	LOG_WARN:   4,
+	LOG_ERR:    3,
+	LOG_CRIT:   2,
+	LOG_ALERT:  1,
+	LOG_EMERG:  0,
 }
 
 func (p Priority) String() string {
@@ -209,10 +264,102 @@ func (p Priority) String() string {
 // abstraction together. There is only ever _one_ instance of this
 // struct in a top-level logger.
 type outch struct {
-	logch  chan qev // buffered channel
+	root   *xLogger // logger that owns the output; sub-loggers share it
+	logch  chan qev // buffered channel of log lines
+	ctlch  chan qev // control events; never discarded
 	closed atomic.Bool
 	wg     sync.WaitGroup
 	pool   sync.Pool
+	bufsz  int // initial capacity of pooled buffers
+
+	// senders hold 'qmu' for reading so that Close() can't close the
+	// queues under them; 'quit' releases the senders blocked on a full
+	// queue when the logger is closed.
+	qmu  sync.RWMutex
+	quit chan struct{}
+
+	// log rotation: compression of rotated logs happens in the background
+	cwg     sync.WaitGroup // in-flight compressions
+	rotfail chan error     // compression failures reported to qrunner
+
+	// closed when the last compression is done; only accessed by the
+	// writer
+	clast chan struct{}
+
+	errfn   atomic.Pointer[func(error)]         // handler for I/O errors
+	rothook atomic.Pointer[func(string, error)] // called after each rotation
+	framer  atomic.Pointer[func([]byte) []byte] // frames each record
+
+	// clones writing to the same log file; they are marked 'stale' when
+	// the file is rotated or reopened and reopen it before their next
+	// write.
+	clmu   sync.Mutex
+	clones []*xLogger
+	stale  atomic.Bool
+
+	// suppression of duplicate lines
+	ddwait atomic.Int64 // time.Duration to wait before flushing repeats
+	dd     dedup
+
+	// sequence numbers for Lseqno; only accessed by the writer
+	seq  uint64
+	sbuf []byte
+
+	// true if the output must be flushed; only accessed by the writer
+	dirty bool
+
+	// don't log when the logger starts and closes
+	quiet bool
+
+	// write in the caller's goroutine instead of qrunner; 'smu'
+	// serializes the writers.
+	direct bool
+	smu    sync.Mutex
+
+	// coalesce queued lines into fewer writes; 'bbuf' holds the pending
+	// lines and is only accessed by the writer.
+	batch bool
+	bbuf  []byte
+
+	// true once a relative timestamp was written since the start or the
+	// last rotation; the first one is written in full. Only accessed by
+	// the writer.
+	relbase bool
+
+	// number of log lines at each level
+	counts  [logMax]atomic.Uint64
+	summary atomic.Bool // log a summary of the counts on Close
+
+	// handling of a full queue
+	overflow atomic.Int32         // OverflowPolicy
+	drops    atomic.Uint64        // lines discarded due to the policy
+	ring     atomic.Pointer[ring] // ring buffer of log lines (if any)
+
+	// lines at or above this level are flushed as soon as they are
+	// written; LOG_NONE is off
+	flvl atomic.Int32
+
+	// format log lines in qrunner instead of the caller's goroutine
+	deferfmt atomic.Bool
+
+	// redaction of sensitive text
+	rmu    sync.Mutex                 // serializes updates to 'redact'
+	redact atomic.Pointer[[]redactor] // applied in order
+
+	// write middleware wrapping the destination
+	mmu sync.Mutex                                  // serializes updates to 'mw'
+	mw  atomic.Pointer[[]func(io.Writer) io.Writer] // applied in order
+	mwc mwcache
+
+	// periodic check of a deleted/renamed log file
+	rwait  atomic.Int64 // time.Duration between checks
+	reopen *time.Ticker // only accessed by qrunner
+
+	// periodic fsync of the log file; 'unsynced' is true if the file
+	// was written since the last fsync. Only accessed by the writer.
+	swait    atomic.Int64 // time.Duration between fsyncs; 0 is O_SYNC
+	fsync    *time.Ticker
+	unsynced bool
 }
 
 // A Logger represents an active logging object that generates lines of
@@ -223,39 +370,125 @@ type Logger interface {
 	// New creates a sub-logger with revised priority and prefix
 	New(prefix string, prio Priority) Logger
 
+	// Clone creates an independent copy of this logger with its own
+	// output queue; closing the clone doesn't close this logger
+	Clone() Logger
+
 	// Close flushes pending I/O and closes this logger instance
 	Close() error
 
+	// Flush waits until the log lines queued so far - by this logger
+	// and every logger sharing its destination - are written out
+	Flush() error
+
+	// CloseTimeout is like Close but waits at most 'd' for pending
+	// I/O to complete; queued messages may be lost on timeout.
+	CloseTimeout(d time.Duration) error
+
+	// IsClosed returns true if this logger (or the logger it was derived
+	// from) is closed
+	IsClosed() bool
+
 	// Loggable returns true if we the logger can write a log at
 	// level 'p'
 	Loggable(p Priority) bool
 
-	// Fatal writes a log message with stack backtrace and invokes panic()
+	// LevelEnabled returns true if the logger is enabled and 'p' is a
+	// valid priority at or above the logger's level
+	LevelEnabled(p Priority) bool
+
+	// Fatal writes a log message with stack backtrace, closes the
+	// logger and exits the program with code 1
 	Fatal(format string, v ...interface{})
 
+	// Alert writes a log message iff the logger priority is LOG_ALERT or higher
+	Alert(format string, v ...interface{})
+
 	// Crit write a log message iff the logger priority is LOG_CRIT or higher
 	Crit(format string, v ...interface{})
 
 	// Error writes a log message iff the logger priority is LOG_ERR or higher
 	Error(format string, v ...interface{})
 
+	// Critf is like Crit but also returns the message as an error
+	Critf(format string, v ...interface{}) error
+
+	// Errorf is like Error but also returns the message as an error
+	Errorf(format string, v ...interface{}) error
+
 	// Warn writes a log message iff the logger priority is LOG_WARN or higher
 	Warn(format string, v ...interface{})
 
+	// Notice writes a log message iff the logger priority is LOG_NOTICE or higher
+	Notice(format string, v ...interface{})
+
 	// Info writes a log message iff the logger priority is LOG_INFO or higher
 	Info(format string, v ...interface{})
 
 	// Debug writes a log message iff the logger priority is LOG_DEBUG or higher
 	Debug(format string, v ...interface{})
 
+	// Log writes a log message at level 'prio' iff the logger can log
+	// at that level
+	Log(prio Priority, format string, v ...interface{})
+
 	// Prio returns the current logger priority
 	Prio() Priority
 
 	// Prefix returns the current logger prefix
 	Prefix() string
 
+	// WithFields creates a sub-logger that appends the given key=value
+	// pairs to every log line
+	WithFields(kv map[string]any) Logger
+
+	// Infow writes 'msg' at level LOG_INFO followed by the alternating
+	// keys and values in 'kv' as key=value pairs
+	Infow(msg string, kv ...any)
+
+	// Warnw is like Infow but writes at level LOG_WARN
+	Warnw(msg string, kv ...any)
+
+	// Errorw is like Infow but writes at level LOG_ERR
+	Errorw(msg string, kv ...any)
+
+	// Printf, Print and Println write at level LOG_INFO; arguments are
+	// handled in the manner of fmt.Printf, fmt.Print and fmt.Println.
+	Printf(format string, v ...interface{})
+	Print(v ...interface{})
+	Println(v ...interface{})
+
+	// Event starts a structured log line at level 'prio'; the line is
+	// written by the Msg() method of the returned Entry
+	Event(prio Priority) *Entry
+
+	// DebugFn writes the message returned by 'fn' iff the logger
+	// priority is LOG_DEBUG or higher; 'fn' isn't called otherwise
+	DebugFn(fn func() string)
+
+	// InfoFn is like DebugFn but writes at level LOG_INFO
+	InfoFn(fn func() string)
+
+	// WarnFn is like DebugFn but writes at level LOG_WARN
+	WarnFn(fn func() string)
+
+	// ErrorFn is like DebugFn but writes at level LOG_ERR
+	ErrorFn(fn func() string)
+
+	// StackTrace returns the stack backtrace of the caller upto 'depth'
+	// frames; a depth of 0 returns the full stack.
+	StackTrace(depth int) string
+
 	// Convert this logger instance into one that looks like the stdlib Logger
 	StdLogger() *stdlog.Logger
+
+	// Writer returns an io.Writer that writes raw bytes - without any
+	// formatting - to the log destination in order with the log lines
+	Writer() io.Writer
+
+	// LevelWriter returns an io.Writer that logs each write as a message
+	// at priority 'p'
+	LevelWriter(p Priority) io.Writer
 }
 
 // A RotatableLogger represents an active _file backed_ Logger instance
@@ -263,20 +496,58 @@ type RotatableLogger interface {
 	Logger
 
 	EnableRotation(hh, mm, ss int, keep int) error
+
+	// RotateHourly enables log rotation every hour at 'mm:ss'
+	RotateHourly(mm, ss int, keep int) error
+
+	// RotateWeekly enables log rotation every week on 'day' at 'hh:mm:ss'
+	RotateWeekly(day time.Weekday, hh, mm, ss int, keep int) error
+
+	// ConfigureRotation enables log rotation as described by the config
+	ConfigureRotation(c *RotateConfig) error
+
+	// Rotate forces an immediate log rotation
+	Rotate() error
+
+	// Sync writes the queued log lines and fsyncs the log file
+	Sync() error
+
+	// Name returns the path of the log file
+	Name() string
 }
 
 // file and syslog backed logger
 type xLogger struct {
-	mu     sync.Mutex // ensures atomic changes to properties
-	prio   Priority   // Logging priority
-	prefix string     // prefix to write at beginning of each line
-	flag   int        // properties
-	out    io.Writer  // destination for output
-	name   string     // file name for file backed logs
-
-	relstart atomic.Bool
-	start    time.Time // start time when the logger was created
-	rot_n    int       // number of days of logs to keep
+	mu     sync.Mutex   // ensures atomic changes to properties
+	prio   atomic.Int32 // Logging priority; see level()
+	prefix string       // prefix to write at beginning of each line
+	flag   int          // properties
+	out    io.Writer    // destination for output
+	name   string       // file name for file backed logs
+
+	now    func() time.Time // source of time
+	start  time.Time        // start time when the logger was created
+	rotcfg RotateConfig     // log rotation config
+	rotnxt time.Time        // time of the next scheduled rotation
+	mtime  time.Time        // last modified time of pre-existing log file
+	pdepth int              // backtrace depth for Panic/Fatal
+	edepth int              // backtrace depth for Error/Crit; 0 is off
+	maxlen int              // max length of a log message; 0 is unlimited
+	cskip  int              // extra stack frames to skip for Lfileloc
+
+	sanitize  bool // escape control characters in messages
+	keepEmpty bool // write empty messages; see SetDropEmpty()
+
+	fields []Field // key=value pairs appended to every line
+	fldstr string  // pre-rendered 'fields'
+
+	rl  atomic.Pointer[ratelimit] // rate limiter (if any)
+	smp atomic.Pointer[sampler]   // sampler of repeated messages (if any)
+
+	fmtr Formatter  // renders log events; nil is the default text layout
+	psep string     // separator of sub-logger prefixes; empty is "."
+	eol  string     // line terminator; empty is the default "\n"
+	pri  *syslogPRI // syslog PRI marker of text lines; nil is off
 
 	ch *outch // output chan
 
@@ -302,6 +573,15 @@ func defaultFlag(flag int) int {
 		flag = Lstdflag
 	}
 
+	// microseconds and nanoseconds are a resolution of the time
+	if (flag & (Lmicroseconds | Lnanoseconds)) != 0 {
+		flag |= Ltime
+	}
+
+	if (flag & Lrelseconds) != 0 {
+		flag |= Lreltime
+	}
+
 	// Reltime overrides any date+timestamp
 	// We however retain Lmicroseconds
 	if (flag & Lreltime) != 0 {
@@ -312,10 +592,35 @@ func defaultFlag(flag int) int {
 		flag |= Lfileloc
 	}
 
-	flag &= ^(lSyslog | lPrefix | lClose)
+	flag &= ^(lInternal | lColor)
 	return flag
 }
 
+// hostname returns the cached host name of this machine
+var hostname = sync.OnceValue(func() string {
+	h, err := os.Hostname()
+	if err != nil || len(h) == 0 {
+		return "localhost"
+	}
+	return h
+})
+
+// process id of this program
+var pid = os.Getpid()
+
+// exitFunc is called by Fatal() to terminate the program
+var exitFunc = os.Exit
+
+// SetExitFunc sets the function called by Fatal() to terminate the
+// program; a nil fn restores the default (os.Exit). This is primarily
+// useful for tests. It is not safe to call concurrently with Fatal().
+func SetExitFunc(fn func(int)) {
+	if fn == nil {
+		fn = os.Exit
+	}
+	exitFunc = fn
+}
+
 // Convert a string to equivalent Priority
 func ToPriority(s string) (p Priority, ok bool) {
 	s = strings.ToUpper(s)
@@ -323,8 +628,16 @@ func ToPriority(s string) (p Priority, ok bool) {
 	return
 }
 
-// make a new logger instance
-func newLogger(out io.Writer, prio Priority, pref string, flag int) *xLogger {
+// make a new logger instance and start its I/O goroutine
+func newLogger(out io.Writer, prio Priority, pref string, flag int, opt *Options) *xLogger {
+	ll := makeLogger(out, prio, pref, flag, opt)
+	ll.run()
+	return ll
+}
+
+// make a new logger instance; the caller must call run() once the
+// instance is fully setup
+func makeLogger(out io.Writer, prio Priority, pref string, flag int, opt *Options) *xLogger {
 	if len(pref) > 0 {
 		flag |= lPrefix
 		pref = fmt.Sprintf("[%s] ", pref)
@@ -335,34 +648,57 @@ func newLogger(out io.Writer, prio Priority, pref string, flag int) *xLogger {
 		prio = LOG_WARN
 	}
 
+	// resolve the host name once - before the first log line
+	if (flag & Lhostname) != 0 {
+		hostname()
+	}
+
+	flag = colorize(flag, out)
+	bufsz := opt.bufSize()
 	ll := &xLogger{
-		prio:   prio,
 		prefix: pref,
 		flag:   flag,
 		out:    out,
+		pdepth: _PANIC_BACKTRACES,
+		now:    time.Now,
 		start:  time.Now().UTC(),
 		ch: &outch{
-			logch: make(chan qev, runtime.NumCPU()),
+			logch: make(chan qev, opt.queueDepth()),
+			ctlch: make(chan qev),
+			quit:  make(chan struct{}),
 			pool: sync.Pool{
-				New: func() any { return make([]byte, 0, _LOGBUFSZ) },
+				New: func() any { return make([]byte, 0, bufsz) },
 			},
+			bufsz:   bufsz,
+			batch:   opt.batch(),
+			direct:  opt.synchronous(),
+			quiet:   opt.quiet(),
+			rotfail: make(chan error, 1),
 		},
 	}
-
-	ll.dprintf(0, LOG_INFO, "Logger at level %s started.", ll.prio.String())
-	ll.ch.wg.Add(1)
-	go ll.qrunner()
+	ll.ch.root = ll
+	ll.prio.Store(int32(prio))
 	return ll
 }
 
+// start the I/O goroutine
+func (l *xLogger) run() {
+	if !l.ch.quiet {
+		l.dprintf(0, LOG_INFO, "Logger at level %s started.", l.level().String())
+	}
+	if !l.ch.direct {
+		l.ch.wg.Add(1)
+		go l.qrunner()
+	}
+}
+
 // Creates a new Logger instance at the given priority. The log output is
 // sent to 'out' - an `io.Writer`.
 // The prefix appears at the beginning of each generated log line.
 // The flag argument defines the logging properties such as timestamps,
-// file & line numbers.
+// file & line numbers. It returns an error if 'out' is nil.
 func New(out io.Writer, prio Priority, prefix string, flag int) (Logger, error) {
-	flag = defaultFlag(flag)
-	return newLogger(out, prio, prefix, defaultFlag(flag)), nil
+	return NewWithOptions(out, prio, prefix, flag, nil)
 }
 
 // Creates a new file-backed logger instance at the given priority.
@@ -370,20 +706,39 @@ func New(out io.Writer, prio Priority, prefix string, flag int) (Logger, error)
 // at the beginning of each generated log line.  The flag argument defines
 // the logging properties such as timestamps, file & line numbers.
 //
-// NB: This is the only constructor that allows you to subsequently
-// configure a log-rotator.
+// NB: This and NewFilelogAppend() are the only constructors that allow
+// you to subsequently configure a log-rotator.
 func NewFilelog(file string, prio Priority, prefix string, flag int) (RotatableLogger, error) {
-	// We use O_RDWR because we will likely rotate the file and it
-	// will help us to seek(0) and read the logs for purposes of
-	// compressing it.
-	logfd, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_SYNC, 0600)
+	return newFilelog(file, prio, prefix, flag, os.O_TRUNC, nil)
+}
+
+// Creates a new file-backed logger instance at the given priority.
+// Unlike NewFilelog(), this function preserves the previous file contents
+// and appends to them. If log rotation is subsequently enabled and the
+// file was last written before the most recent rotation time, it is
+// rotated immediately.
+func NewFilelogAppend(file string, prio Priority, prefix string, flag int) (RotatableLogger, error) {
+	return newFilelog(file, prio, prefix, flag, os.O_APPEND, nil)
+}
+
+func newFilelog(file string, prio Priority, prefix string, flag int, mode int, opt *Options) (*xLogger, error) {
+	// append: clones of the logger write to the same file
+	logfd, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND|os.O_SYNC|mode, 0600)
 	if err != nil {
 		s := fmt.Sprintf("Can't open log file '%s': %s", file, err)
 		return nil, errors.New(s)
 	}
 
-	ll := newLogger(logfd, prio, prefix, defaultFlag(flag)|lClose)
+	// remember when a non-empty file was last written; this is needed for
+	// catch-up rotation.
+	var mtime time.Time
+	if fi, err := logfd.Stat(); err == nil && fi.Size() > 0 {
+		mtime = fi.ModTime()
+	}
+
+	ll := newLogger(logfd, prio, prefix, defaultFlag(flag)|lClose, opt)
 	ll.name = file
+	ll.mtime = mtime
 	return ll, nil
 }
 
@@ -397,14 +752,30 @@ func NewSyslog(prio Priority, prefix string, flag int) (Logger, error) {
 	flag = defaultFlag(flag)
 	tag := path.Base(os.Args[0])
 
-	wr, err := syslog.New(syslog.LOG_NOTICE|syslog.LOG_DAEMON, tag)
+	wr, err := syslogNew(syslog.LOG_NOTICE|syslog.LOG_DAEMON, tag)
 	if err != nil {
 		return nil, fmt.Errorf("%s: syslog: %w", tag, err)
 	}
 
-	return newLogger(wr, prio, prefix, flag|lSyslog), nil
+	return newLogger(wr, prio, prefix, flag|lSyslog, nil), nil
+}
+
+// NewSyslogOrStderr is like NewSyslog but falls back to a logger that
+// writes to STDERR if syslog is unavailable (e.g., no syslogd on the
+// host). The returned logger is always usable; the error (if any)
+// describes why syslog couldn't be used so the caller can log it.
+func NewSyslogOrStderr(prio Priority, prefix string, flag int) (Logger, error) {
+	ll, err := NewSyslog(prio, prefix, flag)
+	if err != nil {
+		return newLogger(os.Stderr, prio, prefix, defaultFlag(flag), nil), err
+	}
+	return ll, nil
 }
 
+// syslogNew connects to the syslog daemon; it's a variable so tests can
+// simulate failures.
+var syslogNew = syslog.New
+
 // Creates a new logging instance. The log destination is controlled by the
 // 'name' argument. It can be one of:
 //
@@ -447,13 +818,135 @@ func NewNoneLogger(prio Priority, pref string) Logger {
 // their own log-prefix (for easier debugging)
 func (l *xLogger) New(prefix string, prio Priority) Logger {
 	if prio <= 0 {
-		prio = l.prio
+		prio = l.level()
+	}
+
+	nl := l.sublogger(prio)
+	if len(prefix) > 0 {
+		if (nl.flag & lPrefix) != 0 {
+			oldpref := barePrefix(nl.prefix)
+			nl.prefix = fmt.Sprintf("[%s%s%s] ", oldpref, nl.prefixSep(), prefix)
+		} else {
+			nl.prefix = fmt.Sprintf("[%s] ", prefix)
+		}
+		nl.flag |= lPrefix
+	} else {
+		nl.prefix = ""
+		nl.flag &= ^lPrefix
+	}
+
+	return nl
+}
+
+// Clone creates a new logger with the same configuration and destination
+// as this one, but with its own output queue and I/O goroutine; it must be
+// closed independently. Closing the clone doesn't close this logger or
+// the shared destination. The clone of a file logger opens the log file
+// by name in append mode and doesn't rotate logs itself: when this logger
+// rotates or reopens its log file, the clone reopens the new file before
+// its next write.
+func (l *xLogger) Clone() Logger {
+	l.mu.Lock()
+	out, isfile := l.out, (l.flag&lClose) != 0 && len(l.name) > 0
+	l.mu.Unlock()
+
+	var err error
+	if _, ok := out.(*os.File); ok && isfile {
+		if out, err = os.OpenFile(l.name, os.O_WRONLY|os.O_CREATE|os.O_APPEND|l.syncFlag(), 0600); err != nil {
+			out, isfile = os.Stderr, false
+		}
+	} else {
+		isfile = false
+	}
+
+	l.mu.Lock()
+	nl := makeLogger(out, l.level(), "", 0, &Options{
+		QueueDepth:  cap(l.ch.logch),
+		BufSize:     l.ch.bufsz,
+		Batch:       l.ch.batch,
+		Synchronous: l.ch.direct,
+		Quiet:       l.ch.quiet,
+	})
+	nl.prefix = l.prefix
+	nl.flag = l.flag &^ (lClose | lSublog | lRotate)
+	nl.name = l.name
+	nl.pdepth = l.pdepth
+	nl.edepth = l.edepth
+	nl.maxlen = l.maxlen
+	nl.cskip = l.cskip
+	nl.sanitize = l.sanitize
+	nl.keepEmpty = l.keepEmpty
+	nl.psep = l.psep
+	nl.pri = l.pri
+	nl.fmtr = l.fmtr
+	nl.eol = l.eol
+	nl.now = l.now
+	nl.fields = l.fields
+	nl.fldstr = l.fldstr
+	if isfile {
+		nl.flag |= lClose
+	}
+	l.mu.Unlock()
+
+	if isfile {
+		l.ch.clmu.Lock()
+		l.ch.clones = append(l.ch.clones, nl)
+		l.ch.clmu.Unlock()
+	}
+
+	if r := l.rl.Load(); r != nil {
+		nl.SetRateLimit(r.n, r.per)
+	}
+	if s := l.smp.Load(); s != nil {
+		nl.SetSampler(s.first, s.thereafter)
 	}
+	nl.ch.errfn.Store(l.ch.errfn.Load())
+	nl.ch.ddwait.Store(l.ch.ddwait.Load())
+	nl.ch.redact.Store(l.ch.redact.Load())
+	nl.ch.mw.Store(l.ch.mw.Load())
+	nl.ch.framer.Store(l.ch.framer.Load())
+	nl.ch.overflow.Store(l.ch.overflow.Load())
+	nl.ch.flvl.Store(l.ch.flvl.Load())
+	nl.ch.summary.Store(l.ch.summary.Load())
+	nl.ch.deferfmt.Store(l.ch.deferfmt.Load())
+	if r := l.ch.ring.Load(); r != nil {
+		// a ring of the same capacity; the pending lines stay here
+		r.Lock()
+		n := r.max
+		r.Unlock()
+		nl.SetRingBuffer(n)
+	}
+
+	nl.run()
+	if err != nil {
+		nl.ioError(fmt.Errorf("logger: %s clone: %w", l.name, err))
+	}
+	return nl
+}
+
+// make a sub-logger that shares our output channel and properties
+func (l *xLogger) sublogger(prio Priority) *xLogger {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 
 	nl := &xLogger{
-		prio: prio,
-		flag: l.flag | lSublog,
-		out:  l.out,
+		prefix: l.prefix,
+		flag:   l.flag | lSublog,
+		out:    l.out,
+		pdepth: l.pdepth,
+		edepth: l.edepth,
+		maxlen: l.maxlen,
+		cskip:  l.cskip,
+		fmtr:   l.fmtr,
+		eol:    l.eol,
+		now:    l.now,
+		fields: l.fields,
+		fldstr: l.fldstr,
+
+		psep:      l.psep,
+		pri:       l.pri,
+		sanitize:  l.sanitize,
+		keepEmpty: l.keepEmpty,
 
 		// We use the same start time for relative-timestamps; the output
 		// destination is the same regardless of whether a Logger instance
@@ -461,33 +954,56 @@ func (l *xLogger) New(prefix string, prio Priority) Logger {
 		start: l.start,
 		ch:    l.ch,
 	}
-
-	if len(prefix) > 0 {
-		if (l.flag & lPrefix) != 0 {
-			oldpref := barePrefix(l.prefix)
-			nl.prefix = fmt.Sprintf("[%s.%s] ", oldpref, prefix)
-		} else {
-			nl.prefix = fmt.Sprintf("[%s] ", prefix)
-		}
-	}
-
+	nl.prio.Store(int32(prio))
+	nl.rl.Store(l.rl.Load())
+	nl.smp.Store(l.smp.Load())
 	return nl
 }
 
 // Close the logger and wait for I/O to complete
 func (l *xLogger) Close() error {
-	if 0 != (l.flag & lSublog) {
+	return l.closeTimeout(0)
+}
+
+// CloseTimeout closes the logger and waits up to 'd' for queued I/O to
+// complete; a 'd' <= 0 waits forever. If the output writer doesn't drain
+// the queue in time, CloseTimeout returns an error and abandons the
+// drain: any pending log messages may be lost and the underlying writer
+// is not closed. In either case, the logger accepts no further writes.
+func (l *xLogger) CloseTimeout(d time.Duration) error {
+	return l.closeTimeout(d)
+}
+
+func (l *xLogger) closeTimeout(d time.Duration) error {
+	if 0 != (l.Flags() & lSublog) {
 		return nil
 	}
 
-	if !l.ch.closed.Swap(true) {
-		close(l.ch.logch)
-		l.ch.wg.Wait()
+	closed, err := l.drainTimeout(d)
+	if err != nil {
+		return err
+	}
+
+	if closed {
+		// compression may have failed after qrunner finished
+		select {
+		case err := <-l.ch.rotfail:
+			l.dprintf(0, LOG_ERR, "%s", err)
+			l.ioError(err)
+		default:
+		}
+
+		if l.ch.summary.Load() {
+			l.dprintf(0, LOG_INFO, "%s", l.closeSummary())
+		}
 
 		// Log when we close the logger and include the caller info
-		l.dprintf(1, LOG_INFO, "xLogger at level %s closed.", l.prio.String())
+		if !l.ch.quiet {
+			l.dprintf(2, LOG_INFO, "xLogger at level %s closed.", l.level().String())
+		}
+		l.flush()
 
-		if (l.flag & lClose) != 0 {
+		if (l.Flags() & lClose) != 0 {
 			if fd, ok := l.out.(io.WriteCloser); ok {
 				return fd.Close()
 			}
@@ -496,47 +1012,116 @@ func (l *xLogger) Close() error {
 	return nil
 }
 
-// Enable log rotation to happen every day at 'hh:mm:ss' (24-hour
-// representation); keep upto 'max' previous logs. Rotated logs are
-// gzip-compressed.
-func (l *xLogger) EnableRotation(hh, mm, ss int, max int) error {
-	l.mu.Lock()
-	defer l.mu.Unlock()
+// drain closes the output channel and waits for all queued I/O to
+// complete. It returns true if this call closed the channel.
+func (l *xLogger) drain() bool {
+	closed, _ := l.drainTimeout(0)
+	return closed
+}
+
+// drainTimeout is like drain but waits at most 'd' for the queued I/O
+// to complete; it returns an error if the wait timed out.
+func (l *xLogger) drainTimeout(d time.Duration) (bool, error) {
+	if l.ch.closed.Swap(true) {
+		return false, nil
+	}
+
+	close(l.ch.quit)
+	l.ch.qmu.Lock()
+	close(l.ch.logch)
+	close(l.ch.ctlch)
+	l.ch.qmu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		if l.ch.direct {
+			// wait for in-flight writes; there is no qrunner to
+			// flush the pending output.
+			l.ch.smu.Lock()
+			l.flushRepeats()
+			l.flushBatch()
+			l.stopSync()
+			l.ch.smu.Unlock()
+		}
+		l.ch.wg.Wait()
+		l.ch.cwg.Wait()
+		close(done)
+	}()
 
-	if (l.flag & lClose) == 0 {
-		return fmt.Errorf("%s: logger is not file backed", l.prefix)
+	if d <= 0 {
+		<-done
+		return true, nil
 	}
 
-	if hh < 0 || hh > 23 || mm < 0 || mm > 59 || ss < 0 || ss > 59 {
-		return fmt.Errorf("invalid rotation config %d:%d.%d", hh, mm, ss)
+	select {
+	case <-done:
+		return true, nil
+	case <-time.After(d):
+		return true, fmt.Errorf("%s: close timed out after %s; pending logs may be lost", l.Prefix(), d)
 	}
+}
+
+// Name returns the path of the log file; it is empty if the logger isn't
+// file backed. Rotation and reopening of the log file keep the path.
+// Sub-loggers return the path of the logger they were derived from.
+func (l *xLogger) Name() string {
+	return l.ch.root.name
+}
 
-	n := time.Now().UTC()
+// Flush waits until every log line queued before the call is written to
+// the destination and the destination is flushed (if it has a Flush or
+// Sync method). Sub-loggers share the queue of their parent: flushing
+// any of them flushes the lines of all of them.
+func (l *xLogger) Flush() error {
+	done := make(chan error, 1)
+	if !l.qevent(qev{ty: _QEV_FLUSH, done: done}) {
+		return fmt.Errorf("%s: logger is closed", l.Prefix())
+	}
+	return <-done
+}
 
-	// This is the time for next file-rotation
-	x := time.Date(n.Year(), n.Month(), n.Day(), hh, mm, ss, 0, n.Location())
+// Rotate forces an immediate rotation of the log file and returns
+// after the rotation is complete. Rotation must've been enabled via
+// EnableRotation().
+func (l *xLogger) Rotate() error {
+	// the log file belongs to the root logger
+	l = l.ch.root
 
-	// For debugging log-rotate logic
-	//x  = n.Add(2 * time.Minute)
+	l.mu.Lock()
+	flag, prefix := l.flag, l.prefix
+	l.mu.Unlock()
 
-	// If we ended up in "yesterday", then set the reminder
-	// for the "next day"
-	if x.Before(n) {
-		x = x.Add(24 * time.Hour)
+	if len(l.name) == 0 {
+		return fmt.Errorf("%s: logger is not file backed", prefix)
+	}
+	if (flag & lRotate) == 0 {
+		return fmt.Errorf("%s: log rotation is not enabled", prefix)
 	}
 
-	if max <= 0 {
-		max = _MAX_LOGFILES
+	done := make(chan error, 1)
+	if !l.qevent(qev{ty: _QEV_ROTATE, done: done}) {
+		return fmt.Errorf("%s: logger is closed", prefix)
 	}
+	return <-done
+}
 
-	l.Info("logger: Enabled daily log-rotation (keep %d days); first rotation at %s",
-		max, x.Format(time.RFC822Z))
+// Enable log rotation to happen every day at 'hh:mm:ss' (24-hour
+// representation, UTC); keep upto 'max' previous logs. Rotated logs are
+// gzip-compressed.
+func (l *xLogger) EnableRotation(hh, mm, ss int, max int) error {
+	return l.ConfigureRotation(&RotateConfig{Hour: hh, Minute: mm, Second: ss, Keep: max})
+}
 
-	l.flag |= lRotate
-	l.rot_n = max
-	d := x.Sub(n)
-	time.AfterFunc(d, l.qtimer)
-	return nil
+// Enable log rotation to happen every hour at 'mm:ss'; keep upto 'max'
+// previous logs.
+func (l *xLogger) RotateHourly(mm, ss int, max int) error {
+	return l.ConfigureRotation(&RotateConfig{Interval: Hourly, Minute: mm, Second: ss, Keep: max})
+}
+
+// Enable log rotation to happen every week on 'day' at 'hh:mm:ss' (24-hour
+// representation, UTC); keep upto 'max' previous logs.
+func (l *xLogger) RotateWeekly(day time.Weekday, hh, mm, ss int, max int) error {
+	return l.ConfigureRotation(&RotateConfig{Interval: Weekly, Weekday: day, Hour: hh, Minute: mm, Second: ss, Keep: max})
 }
 
 // Enqueue a log-write to happen asynchronously
@@ -545,8 +1130,22 @@ func (l *xLogger) Output(calldepth int, prio Priority, s string, v ...interface{
 		calldepth += 1
 	}
 
-	t := l.ofmt(calldepth, prio, s, v...)
-	l.qwrite(t)
+	if l.ch.deferfmt.Load() {
+		l.qevent(l.dfmt(calldepth, prio, s, v))
+		return
+	}
+	l.qevent(l.ofmt(time.Time{}, nil, calldepth, prio, s, v...))
+}
+
+// OutputAt is like Output but the log line has the time 't' instead of
+// the current time - e.g., to replay historical events. With Lreltime,
+// the relative timestamp is the offset of 't' from the start time.
+func (l *xLogger) OutputAt(t time.Time, calldepth int, prio Priority, s string, v ...interface{}) {
+	if calldepth > 0 {
+		calldepth += 1
+	}
+
+	l.qevent(l.ofmt(t, nil, calldepth, prio, s, v...))
 }
 
 // Dump stack backtrace for 'depth' levels
@@ -554,77 +1153,301 @@ func (l *xLogger) Output(calldepth int, prio Priority, s string, v ...interface{
 // NB: The absolute pathname of the file is used in the backtrace;
 // regardless of the logger flags requesting shortfile.
 func (l *xLogger) Backtrace(depth int) {
-	s := backTrace(depth+1, l.flag)
-	l.qwrite([]byte(s))
+	s := backTrace(0, depth+1, l.Flags())
+	l.qwrite([]byte(s), 0)
+}
+
+// StackTrace returns the stack backtrace of the caller for 'depth' levels
+// in the same format as Backtrace().
+func (l *xLogger) StackTrace(depth int) string {
+	return backTrace(0, depth, l.Flags())
 }
 
-// Predicate that returns true if we can log at level prio
+// LevelEnabled returns true if a message at level 'prio' will be logged:
+// i.e., the logger isn't disabled (its level is not LOG_NONE) and 'prio'
+// is at or above the level of the logger. Invalid priorities are never
+// enabled.
+func (l *xLogger) LevelEnabled(prio Priority) bool {
+	return levelEnabled(l.level(), prio)
+}
+
+// Loggable is the same as LevelEnabled
 func (l *xLogger) Loggable(prio Priority) bool {
-	return l.prio > LOG_NONE && prio >= l.prio
+	return l.LevelEnabled(prio)
+}
+
+// return true if a logger at level 'lvl' logs messages at level 'prio'
+func levelEnabled(lvl, prio Priority) bool {
+	if prio <= LOG_NONE || prio >= logMax {
+		return false
+	}
+	return lvl > LOG_NONE && prio >= lvl
+}
+
+// IsClosed returns true if the logger is closed; sub-loggers share the
+// state of the logger they were derived from. Messages logged after the
+// logger is closed are dropped.
+func (l *xLogger) IsClosed() bool {
+	return l.ch.closed.Load()
+}
+
+// return true if a message at level prio with the format string 'key'
+// should be logged now
+func (l *xLogger) enabled(prio Priority, key string) bool {
+	if l.ch.closed.Load() {
+		return false
+	}
+	if l.Loggable(prio) && l.sample(prio, key) && l.allow() {
+		l.ch.counts[prio].Add(1)
+		return true
+	}
+	return false
+}
+
+// SetCloseSummary sets whether Close() logs a summary line - the number
+// of lines logged at each level, the lines dropped and the uptime - just
+// before the line announcing the close. It is off by default; the
+// setting is shared by all sub-loggers.
+func (l *xLogger) SetCloseSummary(on bool) {
+	l.ch.summary.Store(on)
+}
+
+// return the summary line logged on Close
+func (l *xLogger) closeSummary() string {
+	var b strings.Builder
+
+	b.WriteString("Logger summary:")
+	for p := LOG_DEBUG; p < logMax; p++ {
+		fmt.Fprintf(&b, " %s=%d", p, l.ch.counts[p].Load())
+	}
+
+	l.mu.Lock()
+	up := l.now().Sub(l.start)
+	l.mu.Unlock()
+
+	fmt.Fprintf(&b, " dropped=%d uptime=%s", l.ch.drops.Load(), up.Round(time.Millisecond))
+	return b.String()
+}
+
+// Counts returns the number of lines logged at each level by this logger
+// and all its sub-loggers.
+func (l *xLogger) Counts() map[Priority]uint64 {
+	m := make(map[Priority]uint64)
+	for p := LOG_DEBUG; p < logMax; p++ {
+		m[p] = l.ch.counts[p].Load()
+	}
+	return m
 }
 
 // Printf calls l.Output to print to the logger.
 // Arguments are handled in the manner of fmt.Printf.
 func (l *xLogger) Printf(format string, v ...interface{}) {
-	l.Output(0, LOG_INFO, format, v...)
+	l.Output(2, LOG_INFO, format, v...)
+}
+
+// Print calls l.Output to print to the logger.
+// Arguments are handled in the manner of fmt.Print.
+func (l *xLogger) Print(v ...interface{}) {
+	l.Output(2, LOG_INFO, "%s", fmt.Sprint(v...))
+}
+
+// Println calls l.Output to print to the logger.
+// Arguments are handled in the manner of fmt.Println.
+func (l *xLogger) Println(v ...interface{}) {
+	l.Output(2, LOG_INFO, "%s", fmt.Sprintln(v...))
 }
 
 // Panicf is equivalent to l.Printf() followed by a call to panic().
 func (l *xLogger) Panic(format string, v ...interface{}) {
-	bt := backTrace(_PANIC_BACKTRACES, l.flag)
+	bt := backTrace(0, l.panicDepth(), l.Flags())
 	s := fmt.Sprintf(format, v...)
+	l.ch.counts[LOG_EMERG].Add(1)
 	l.Output(2, LOG_EMERG, "%s:\n%s", s, bt)
 	l.Close()
 	panic(s)
 }
 
-// Fatalf is equivalent to l.Printf() followed by a call to os.Exit(1).
+// Fatal is equivalent to l.Printf() followed by a call to os.Exit(1).
+// The stack backtrace is logged and pending I/O is flushed before the
+// program exits. Deferred functions are NOT run.
 func (l *xLogger) Fatal(format string, v ...interface{}) {
-	l.Panic(format, v...)
+	l.fatal(1, format, v...)
+}
+
+// fatal does the work of Fatal(); 'skip' is the number of frames between
+// the user's call site and us.
+func (l *xLogger) fatal(skip int, format string, v ...interface{}) {
+	bt := backTrace(skip, l.panicDepth(), l.Flags())
+	s := fmt.Sprintf(format, v...)
+	l.ch.counts[LOG_EMERG].Add(1)
+	l.Output(2+skip, LOG_EMERG, "%s:\n%s", s, bt)
+	l.Close()
+
+	// sub-loggers don't own the output channel; but we're about to exit -
+	// so drain the shared queue regardless.
+	l.drain()
+	exitFunc(1)
+}
+
+// Alert prints logs at level ALERT
+func (l *xLogger) Alert(format string, v ...interface{}) {
+	if l.enabled(LOG_ALERT, format) {
+		l.Output(2, LOG_ALERT, format, v...)
+	}
 }
 
 // Crit prints logs at level CRIT
 func (l *xLogger) Crit(format string, v ...interface{}) {
-	if l.Loggable(LOG_CRIT) {
-		l.Output(2, LOG_CRIT, format, v...)
+	if l.enabled(LOG_CRIT, format) {
+		l.errOutput(2, LOG_CRIT, format, v...)
 	}
 }
 
 // Err prints logs at level ERR
 func (l *xLogger) Error(format string, v ...interface{}) {
-	if l.Loggable(LOG_ERR) {
-		l.Output(2, LOG_ERR, format, v...)
+	if l.enabled(LOG_ERR, format) {
+		l.errOutput(2, LOG_ERR, format, v...)
+	}
+}
+
+// Critf is like Crit but also returns the formatted message as an error;
+// the error is returned even if the message isn't logged. As with
+// fmt.Errorf(), a %w verb wraps its operand.
+func (l *xLogger) Critf(format string, v ...interface{}) error {
+	err := fmt.Errorf(format, v...)
+	if l.enabled(LOG_CRIT, format) {
+		l.errOutput(2, LOG_CRIT, "%s", err)
+	}
+	return err
+}
+
+// Errorf is like Error but also returns the formatted message as an
+// error; the error is returned even if the message isn't logged. As with
+// fmt.Errorf(), a %w verb wraps its operand.
+func (l *xLogger) Errorf(format string, v ...interface{}) error {
+	err := fmt.Errorf(format, v...)
+	if l.enabled(LOG_ERR, format) {
+		l.errOutput(2, LOG_ERR, "%s", err)
+	}
+	return err
+}
+
+// write a log message for Error() and Crit() - with a backtrace of the
+// caller if enabled via SetErrorBacktrace(). The backtrace skips the
+// immediate caller if its location is already logged via Lfileloc.
+// Calldepth is as for Output(): 2 is the caller of the caller of
+// errOutput.
+func (l *xLogger) errOutput(calldepth int, prio Priority, format string, v ...interface{}) {
+	l.mu.Lock()
+	depth, flag := l.edepth, l.flag
+	l.mu.Unlock()
+
+	if depth == 0 {
+		l.Output(calldepth+1, prio, format, v...)
+		return
+	}
+
+	skip := calldepth - 1
+	if (flag & Lfileloc) != 0 {
+		skip++
+	}
+
+	bt := backTrace(skip, depth, flag)
+	s := fmt.Appendf(nil, format, v...)
+	if (flag & Lerrchain) != 0 {
+		s = appendErrChain(s, v)
 	}
+	l.Output(calldepth+1, prio, "%s\n%s", s, bt)
 }
 
 // Warn prints logs at level WARNING
 func (l *xLogger) Warn(format string, v ...interface{}) {
-	if l.Loggable(LOG_WARN) {
-		l.Output(0, LOG_WARN, format, v...)
+	if l.enabled(LOG_WARN, format) {
+		l.Output(2, LOG_WARN, format, v...)
+	}
+}
+
+// Notice prints logs at level NOTICE
+func (l *xLogger) Notice(format string, v ...interface{}) {
+	if l.enabled(LOG_NOTICE, format) {
+		l.Output(2, LOG_NOTICE, format, v...)
 	}
 }
 
 // Info prints logs at level INFO
 func (l *xLogger) Info(format string, v ...interface{}) {
-	if l.Loggable(LOG_INFO) {
-		l.Output(0, LOG_INFO, format, v...)
+	if l.enabled(LOG_INFO, format) {
+		l.Output(2, LOG_INFO, format, v...)
 	}
 }
 
 // Debug prints logs at level INFO
 func (l *xLogger) Debug(format string, v ...interface{}) {
-	if l.Loggable(LOG_DEBUG) {
+	if l.enabled(LOG_DEBUG, format) {
 		l.Output(2, LOG_DEBUG, format, v...)
 	}
 }
 
+// DebugFn writes the message returned by 'fn' iff the logger priority is
+// LOG_DEBUG or higher; expensive messages cost nothing when the level is
+// disabled.
+func (l *xLogger) DebugFn(fn func() string) {
+	if l.enabled(LOG_DEBUG, "") {
+		l.Output(2, LOG_DEBUG, "%s", fn())
+	}
+}
+
+// InfoFn is like DebugFn but writes at level LOG_INFO
+func (l *xLogger) InfoFn(fn func() string) {
+	if l.enabled(LOG_INFO, "") {
+		l.Output(2, LOG_INFO, "%s", fn())
+	}
+}
+
+// WarnFn is like DebugFn but writes at level LOG_WARN
+func (l *xLogger) WarnFn(fn func() string) {
+	if l.enabled(LOG_WARN, "") {
+		l.Output(2, LOG_WARN, "%s", fn())
+	}
+}
+
+// ErrorFn is like DebugFn but writes at level LOG_ERR
+func (l *xLogger) ErrorFn(fn func() string) {
+	if l.enabled(LOG_ERR, "") {
+		l.errOutput(2, LOG_ERR, "%s", fn())
+	}
+}
+
+// Log prints logs at level 'prio'; levels above LOG_EMERG are treated as
+// LOG_EMERG. Unlike Fatal() and Panic(), LOG_EMERG neither prints a
+// backtrace nor stops the program.
+func (l *xLogger) Log(prio Priority, format string, v ...interface{}) {
+	if prio >= logMax {
+		prio = LOG_EMERG
+	}
+	if l.enabled(prio, format) {
+		l.Output(2, prio, format, v...)
+	}
+}
+
 // Manipulate properties of loggers
 
 // Return priority of this logger
 func (l *xLogger) Prio() Priority {
-	l.mu.Lock()
-	defer l.mu.Unlock()
-	return l.prio
+	return l.level()
+}
+
+// SetPrio sets the priority of this logger to 'prio'. Every sub-logger
+// has its own priority: raising the verbosity of a sub-logger (e.g., to
+// debug one module) doesn't affect its parent or siblings, and changing
+// the priority of a parent doesn't affect existing sub-loggers.
+func (l *xLogger) SetPrio(prio Priority) {
+	l.prio.Store(int32(prio))
+}
+
+// return the current priority
+func (l *xLogger) level() Priority {
+	return Priority(l.prio.Load())
 }
 
 // Flags returns the output flags for the logger.
@@ -634,75 +1457,292 @@ func (l *xLogger) Flags() int {
 	return l.flag
 }
 
-// Prefix returns the output prefix for the logger.
-func (l *xLogger) Prefix() string {
+// SetPanicDepth sets the number of stack frames printed by Panic() and
+// Fatal(); a depth of 0 prints the full stack (upto 64 frames).
+func (l *xLogger) SetPanicDepth(n int) {
+	if n < 0 {
+		n = 0
+	}
+	if n > _MAX_BACKTRACE {
+		n = _MAX_BACKTRACE
+	}
+
 	l.mu.Lock()
-	defer l.mu.Unlock()
-	return l.prefix
+	l.pdepth = n
+	l.mu.Unlock()
 }
 
-// -- Internal functions --
-
-func (l *xLogger) formatHeader(out []byte, t time.Time) []byte {
-	if (l.flag & Lreltime) == 0 {
-		return timestamp(out, t, l.flag)
+// SetErrorBacktrace makes Error() and Crit() (and their 'f' variants)
+// append a backtrace of upto 'depth' frames to each logged line. A depth
+// of 0 disables it (the default).
+func (l *xLogger) SetErrorBacktrace(depth int) {
+	if depth < 0 {
+		depth = 0
+	}
+	if depth > _MAX_BACKTRACE {
+		depth = _MAX_BACKTRACE
 	}
 
-	// if this is the first time, do the full time stamp so we have a
-	// baseline reference
-	if ok := l.relstart.Swap(true); !ok {
-		return timestamp(out, t, l.flag|Ldate|Ltime)
+	l.mu.Lock()
+	l.edepth = depth
+	l.mu.Unlock()
+}
+
+func (l *xLogger) panicDepth() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.pdepth
+}
+
+// SetMaxLineLen limits the formatted log message to 'n' bytes; longer
+// messages are truncated and marked with "...[truncated M bytes]". The
+// timestamp, prefix, file location and fields don't count against the
+// limit. A value of n <= 0 means unlimited (the default).
+func (l *xLogger) SetMaxLineLen(n int) {
+	if n < 0 {
+		n = 0
 	}
-	d := t.Sub(l.start)
-	return fmt.Appendf(out, "+%s", d.String())
+
+	l.mu.Lock()
+	l.maxlen = n
+	l.mu.Unlock()
 }
 
-// Output formats the output for a logging event.  The string s contains
+// SetFlags sets the output flags for the logger. The internal flags
+// describing the output destination are retained.
+func (l *xLogger) SetFlags(flag int) {
+	l.mu.Lock()
+	l.flag = colorize(defaultFlag(flag)|(l.flag&lInternal), l.out)
+	l.mu.Unlock()
+
+	// force StdLogger() to rebuild with the new flags
+	l.stdlogger.Store(nil)
+}
+
+// SetPrefix sets the output prefix for the logger; an empty prefix
+// removes it.
+func (l *xLogger) SetPrefix(prefix string) {
+	l.mu.Lock()
+	if len(prefix) > 0 {
+		l.prefix = fmt.Sprintf("[%s] ", prefix)
+		l.flag |= lPrefix
+	} else {
+		l.prefix = ""
+		l.flag &= ^lPrefix
+	}
+	l.mu.Unlock()
+
+	l.stdlogger.Store(nil)
+}
+
+// SetCallerSkip skips 'n' additional stack frames when finding the
+// source location of a log call (Lfileloc, Lfunc); wrappers of the
+// logger use it so that the location is that of their caller rather than
+// the wrapper. Sub-loggers created after this call inherit the setting.
+func (l *xLogger) SetCallerSkip(n int) {
+	if n < 0 {
+		n = 0
+	}
+
+	l.mu.Lock()
+	l.cskip = n
+	l.mu.Unlock()
+}
+
+// SetDropEmpty sets whether log calls with an empty message are dropped
+// (the default); if 'drop' is false, an empty message produces a line
+// with just the header - e.g., to separate sections of a trace.
+// Sub-loggers created after this call inherit the setting.
+func (l *xLogger) SetDropEmpty(drop bool) {
+	l.mu.Lock()
+	l.keepEmpty = !drop
+	l.mu.Unlock()
+}
+
+// SetPrefixSeparator sets the separator between the prefix of this
+// logger and that of sub-loggers created via New() - e.g., "/" yields
+// "[parent/child]". An empty separator restores the default of ".".
+// Sub-loggers inherit the separator.
+func (l *xLogger) SetPrefixSeparator(sep string) {
+	l.mu.Lock()
+	l.psep = sep
+	l.mu.Unlock()
+}
+
+// return the separator of sub-logger prefixes
+func (l *xLogger) prefixSep() string {
+	if len(l.psep) == 0 {
+		return "."
+	}
+	return l.psep
+}
+
+// SetClock sets the source of time for the logger; a nil fn restores
+// time.Now. The start time (reference for Lreltime) is reset to the
+// current time of the new clock. This is primarily useful for tests.
+func (l *xLogger) SetClock(fn func() time.Time) {
+	if fn == nil {
+		fn = time.Now
+	}
+
+	l.mu.Lock()
+	l.now = fn
+	l.start = fn().UTC()
+	l.mu.Unlock()
+}
+
+// return the current time as per the logger clock
+func (l *xLogger) clock() time.Time {
+	l.mu.Lock()
+	now := l.now
+	l.mu.Unlock()
+	return now()
+}
+
+// Prefix returns the output prefix for the logger.
+func (l *xLogger) Prefix() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.prefix
+}
+
+// -- Internal functions --
+
+// truncate the message starting at b[off:] to 'max' bytes (excluding a
+// trailing newline) without splitting a UTF-8 sequence.
+func truncate(b []byte, off int, max int) []byte {
+	end := len(b)
+	if end > off && b[end-1] == '\n' {
+		end--
+	}
+	if end-off <= max {
+		return b
+	}
+
+	n := off + max
+	for n > off && !utf8.RuneStart(b[n]) {
+		n--
+	}
+	return fmt.Appendf(b[:n], "...[truncated %d bytes]", end-n)
+}
+
+// Output formats the output for a logging event.  The string s contains
 // the text to print after the prefix specified by the flags of the
 // Logger.  A newline is appended if the last character of s is not
 // already a newline.  Calldepth is used to recover the PC and is
 // provided for generality, although at the moment on all pre-defined
-// paths it will be 2.
-func (l *xLogger) ofmt(calldepth int, prio Priority, s string, v ...interface{}) []byte {
+// paths it will be 2. The log line has the time 't'; a zero 't' is the
+// current time. A non-nil 'cs' is the call site captured by the caller
+// (see SetDeferredFormat) and is used instead of 'calldepth'.
+//
+// ofmt returns a log event for qrunner.
+func (l *xLogger) ofmt(t time.Time, cs *callsite, calldepth int, prio Priority, s string, v ...interface{}) qev {
 	b := l.getBuf()
 
-	if len(s) == 0 {
-		return b
+	l.mu.Lock()
+	flag, prefix, clock, start, maxlen := l.flag, l.prefix, l.now, l.start, l.maxlen
+	fmtr, eol, sanitize, pri := l.fmtr, l.eol, l.sanitize, l.pri
+	keepEmpty, cskip := l.keepEmpty, l.cskip
+	l.mu.Unlock()
+
+	if len(s) == 0 && !keepEmpty {
+		return qev{ty: _QEV_LOG, buf: b}
+	}
+	if t.IsZero() {
+		t = clock()
 	}
 
-	// Put the timestamp and priority only if we are NOT syslog
-	if (l.flag & lSyslog) == 0 {
-		now := time.Now().UTC()
-		b = fmt.Appendf(b, "<%d>:", prio)
-		b = l.formatHeader(b, now)
-		b = append(b, ' ')
+	e := Event{
+		Prio:   prio,
+		Time:   t.UTC(),
+		Start:  start,
+		Flags:  flag,
+		Prefix: prefix,
+		Fields: l.fields,
+		fldstr: l.fldstr,
+		pri:    pri,
 	}
 
-	if (l.flag & lPrefix) != 0 {
-		b = append(b, l.prefix...)
+	if cs == nil && calldepth > 0 && (flag&Lfileloc) > 0 {
+		cs = &callsite{}
+		cs.pc, cs.file, cs.line, cs.ok = runtime.Caller(calldepth + cskip)
 	}
 
-	if calldepth > 0 && (l.flag&Lfileloc) > 0 {
-		var ok bool
-		_, file, line, ok := runtime.Caller(calldepth)
+	if cs != nil && (flag&Lfileloc) > 0 {
+		pc, file, line, ok := cs.pc, cs.file, cs.line, cs.ok
 		if !ok {
 			file = "???"
 			line = 0
 		}
 
 		// if caller requested short names, trim it
-		if (l.flag & Lfullpath) == 0 {
+		if (flag & Lfullpath) == 0 {
 			file = path.Base(file)
 		}
-		b = fmt.Appendf(b, "(%s:%d) ", file, line)
+
+		e.File, e.Line = file, line
+		if (flag & Lfunc) != 0 {
+			e.Func = funcName(pc, ok, flag)
+		}
 	}
 
-	b = fmt.Appendf(b, s, v...)
-	if len(b) > 0 && b[len(b)-1] != '\n' {
-		b = append(b, '\n')
+	m := fmt.Appendf(l.getBuf(), s, v...)
+	if n := len(m); n > 0 && m[n-1] == '\n' {
+		m = m[:n-1]
+	}
+	if (flag & Lerrchain) != 0 {
+		m = appendErrChain(m, v)
+	}
+
+	if sanitize {
+		x := appendSanitized(l.getBuf(), m)
+		l.putBuf(m)
+		m = x
 	}
 
-	return b
+	// redact before truncating so partial matches don't escape
+	m = l.redact(m, 0)
+	if maxlen > 0 {
+		m = truncate(m, 0, maxlen)
+	}
+	e.Msg = m
+
+	x := qev{ty: _QEV_LOG, prio: prio}
+	if l.ch.ddwait.Load() > 0 {
+		x.key = dedupKey(&e)
+	}
+	x.buf, x.hoff, x.moff = l.format(b, fmtr, &e)
+	x.buf = setEOL(x.buf, eol)
+	if fmtr == nil {
+		x.seq = (flag & Lseqno) != 0
+		if (flag & Lreltime) == 0 {
+			x.hoff = 0
+		}
+		x.t, x.tflag = e.Time, flag
+	}
+
+	l.putBuf(m)
+	return x
+}
+
+// render 'e' via 'fmtr' or the default text layout if nil; return the
+// extended buffer, the offset of the timestamp and the offset of the
+// text following the timestamp. The message in 'e' is already
+// redacted; the fields are not.
+func (l *xLogger) format(b []byte, fmtr Formatter, e *Event) ([]byte, int, int) {
+	if fmtr == nil {
+		b, hoff, moff, foff := appendText(b, e)
+		if foff < len(b)-1 {
+			b = l.redact(b, foff)
+		}
+		return b, hoff, moff
+	}
+
+	b = fmtr.Format(b, *e)
+	if len(e.Fields) > 0 {
+		b = l.redact(b, 0)
+	}
+	return b, 0, 0
 }
 
 // printf style logger that write directly to the underlying writer without going
@@ -711,39 +1751,269 @@ func (l *xLogger) dprintf(depth int, pr Priority, s string, args ...interface{})
 	if depth > 0 {
 		depth += 1
 	}
-	x := l.ofmt(depth, pr, s, args...)
-	l.out.Write(x)
+	e := l.ofmt(time.Time{}, nil, depth, pr, s, args...)
+	l.write(&e)
 
 	// don't forget to return the buffer to the pool
-	l.putBuf(x)
+	l.putBuf(e.buf)
+}
+
+// write a log event to the output. This must only be called from qrunner
+// or when qrunner isn't running.
+func (l *xLogger) write(e *qev) {
+	l.emit(e, false)
+}
+
+// write a log event to the output or, if 'batch' is true, append it to
+// the pending batch of writes. This must only be called from qrunner or
+// when qrunner isn't running.
+func (l *xLogger) emit(e *qev, batch bool) {
+	b := e.buf
+	if len(b) == 0 {
+		// the formatter rendered nothing
+		return
+	}
+
+	// the first relative timestamp after start or rotation is absolute so
+	// there is a frame of reference for the lines that follow.
+	if e.hoff > 0 {
+		if !l.ch.relbase {
+			x := append(l.getBuf(), b[:e.hoff]...)
+			x = timestamp(x, e.t, e.tflag|Ldate|Ltime)
+			b = append(x, b[e.moff-1:]...)
+			defer l.putBuf(b)
+		}
+		l.ch.relbase = true
+	}
+
+	if e.seq {
+		// sequence numbers are assigned in the order of writes
+		l.ch.seq++
+		x := itoa(l.ch.sbuf[:0], int(l.ch.seq), 0)
+		x = append(x, ' ')
+		b = append(x, b...)
+		l.ch.sbuf = b[:0]
+	}
+
+	if fn := l.ch.framer.Load(); fn != nil && len(b) > 0 {
+		b = (*fn)(b)
+	}
+
+	if batch {
+		l.ch.bbuf = append(l.ch.bbuf, b...)
+		if len(l.ch.bbuf) >= _BATCHMAX {
+			l.flushBatch()
+		}
+		return
+	}
+
+	// preserve the order of writes
+	l.flushBatch()
+	if w, ok := l.out.(*syslogOut); ok {
+		w.cur = e.prio
+	}
+	l.output(b)
+}
+
+// write the pending batch of log lines (if any)
+func (l *xLogger) flushBatch() {
+	// reset first: a panic in the writer mustn't replay the batch
+	if b := l.ch.bbuf; len(b) > 0 {
+		l.ch.bbuf = b[:0]
+		l.output(b)
+	}
+}
+
+// SetFramer sets a function that frames each log record just before it
+// is written - e.g., to prepend its length for a length-delimited
+// transport; a nil fn restores the default of writing records as is. 'fn'
+// is called from the I/O goroutine with the complete record (including
+// the line terminator); it may modify or extend 'buf' and returns the
+// framed record. It must not retain 'buf'. The framer is shared by all
+// sub-loggers; with batching (Options.Batch), each record in a batch is
+// framed.
+func (l *xLogger) SetFramer(fn func(buf []byte) []byte) {
+	if fn == nil {
+		l.ch.framer.Store(nil)
+	} else {
+		l.ch.framer.Store(&fn)
+	}
+}
+
+// write 'b' to the destination
+func (l *xLogger) output(b []byte) {
+	if _, err := l.writer().Write(b); err != nil {
+		l.ioError(fmt.Errorf("logger: write: %w", err))
+	}
+	l.ch.dirty = true
+	l.ch.unsynced = true
+}
+
+// SetFlushLevel makes each log line at level 'prio' or above flushed -
+// and fsynced, if the log file is synced periodically - right after it is
+// written; lines below 'prio' stay buffered. LOG_NONE (the default) turns
+// this off. The level is shared by all sub-loggers.
+func (l *xLogger) SetFlushLevel(prio Priority) {
+	if prio < LOG_NONE || prio >= logMax {
+		prio = LOG_NONE
+	}
+	l.ch.flvl.Store(int32(prio))
+}
+
+// flush a buffered output writer - one that has a Flush() or Sync()
+// method - if anything was written since the last flush. Files are
+// skipped: log files are opened with O_SYNC (or synced periodically)
+// and syncing the standard streams is meaningless.
+func (l *xLogger) flush() {
+	l.flushBatch()
+	if !l.ch.dirty {
+		return
+	}
+	l.ch.dirty = false
+
+	var err error
+	switch w := l.out.(type) {
+	case *os.File:
+		return
+	case interface{ Flush() error }:
+		err = w.Flush()
+	case interface{ Sync() error }:
+		err = w.Sync()
+	default:
+		return
+	}
+
+	if err != nil {
+		l.ioError(fmt.Errorf("logger: flush: %w", err))
+	}
 }
 
 // type of event that goes into the qrunner channel
 type qevt int
 
 const (
-	_QEV_LOG   = iota // event type is to log a message
-	_QEV_TIMER        // event signals timer expiry (log rotation)
+	_QEV_LOG    = iota // event type is to log a message
+	_QEV_TIMER         // event signals timer expiry (log rotation)
+	_QEV_ROTATE        // event requests an immediate log rotation
+	_QEV_REOPEN        // event signals a change in the reopen check interval
+	_QEV_FSYNC         // event signals a change in the fsync policy
+	_QEV_FLUSH         // event requests a flush of the queued log lines
+	_QEV_SYNC          // event requests a flush and an fsync of the log file
 )
 
 // qev records the action to be taken by the qrunner goroutine
 type qev struct {
-	ty  qevt
-	buf []byte
+	ty    qevt
+	buf   []byte
+	moff  int        // offset of the log message after the timestamp
+	hoff  int        // offset of the relative timestamp (if any)
+	t     time.Time  // time of the log message
+	tflag int        // flags in effect when the timestamp was rendered
+	seq   bool       // prepend a sequence number when writing
+	prio  Priority   // priority of the log message
+	key   uint64     // dedup key of the log message; 0 if not computed
+	dfr   *dfmtArgs  // if non-nil, the log message is formatted by qrunner
+	done  chan error // if non-nil, qrunner sends the result of the action
 }
 
 // Enqueue a write to be flushed by qrunner()
 // Senders are responsible for closing the channel - but only once.
-func (l *xLogger) qwrite(b []byte) {
-	if !l.ch.closed.Load() {
-		l.ch.logch <- qev{_QEV_LOG, b}
+// 'moff' is the offset of the text following the timestamp in 'b'.
+// 'b' must not be owned by the caller: it is returned to the pool after
+// it is written.
+func (l *xLogger) qwrite(b []byte, moff int) {
+	if l.ch.closed.Load() {
+		return
 	}
+
+	// raw writes must be formatted too
+	l.mu.Lock()
+	fmtr, flag, clock, eol := l.fmtr, l.flag, l.now, l.eol
+	l.mu.Unlock()
+
+	if fmtr != nil {
+		if n := len(b); n > 0 && b[n-1] == '\n' {
+			b = b[:n-1]
+		}
+
+		e := Event{
+			Prio:   LOG_INFO,
+			Time:   clock().UTC(),
+			Flags:  flag &^ (Lhostname | Lpid),
+			Msg:    b,
+			Fields: l.fields,
+		}
+		x := fmtr.Format(l.getBuf(), e)
+		if len(e.Fields) > 0 {
+			x = l.redact(x, 0)
+		}
+		l.putBuf(b)
+		b, moff = setEOL(x, eol), 0
+	}
+	l.qevent(qev{ty: _QEV_LOG, buf: b, moff: moff})
 }
 
 // Enqueue a timer expirty to be handled by qrunner()
 func (l *xLogger) qtimer() {
-	if !l.ch.closed.Load() {
-		l.ch.logch <- qev{_QEV_TIMER, nil}
+	l.qevent(qev{ty: _QEV_TIMER})
+}
+
+// SetErrorHandler sets a function that is called when writing to the log
+// destination or rotating the logs fails; a nil fn removes the handler.
+// The handler is shared by all sub-loggers. It is called from the I/O
+// goroutine: it must NOT log via this logger (or any of its sub-loggers)
+// and must not block - doing either can deadlock the logger.
+func (l *xLogger) SetErrorHandler(fn func(error)) {
+	if fn == nil {
+		l.ch.errfn.Store(nil)
+	} else {
+		l.ch.errfn.Store(&fn)
+	}
+}
+
+// report an I/O error to the error handler (if any)
+func (l *xLogger) ioError(err error) {
+	if fn := l.ch.errfn.Load(); fn != nil {
+		(*fn)(err)
+	}
+}
+
+// Enqueue an event for qrunner(); returns false if the logger is closed
+func (l *xLogger) qevent(e qev) bool {
+	if l.ch.closed.Load() {
+		return false
+	}
+
+	// a dropped message (see SetDropEmpty) isn't written
+	if e.ty == _QEV_LOG && e.dfr == nil && len(e.buf) == 0 {
+		l.putBuf(e.buf)
+		return true
+	}
+
+	if l.ch.direct {
+		// the root logger owns the output: sub-loggers have a stale
+		// copy of it once the log file is rotated or reopened.
+		l.ch.root.handleSync(&e)
+		return true
+	}
+
+	l.ch.qmu.RLock()
+	defer l.ch.qmu.RUnlock()
+
+	// we may have lost the race with Close()
+	if l.ch.closed.Load() {
+		return false
+	}
+
+	if e.ty == _QEV_LOG {
+		return l.enqueue(e)
+	}
+
+	select {
+	case l.ch.ctlch <- e:
+		return true
+	case <-l.ch.quit:
+		return false
 	}
 }
 
@@ -751,25 +2021,214 @@ func (l *xLogger) qtimer() {
 func (l *xLogger) qrunner() {
 	defer l.ch.wg.Done()
 
-	for e := range l.ch.logch {
-		switch e.ty {
-		case _QEV_LOG:
-			l.out.Write(e.buf)
+	ctl := l.ch.ctlch
+	for {
+		var e qev
+		var ok bool
+
+		select {
+		case e, ok = <-l.ch.logch:
+			if !ok {
+				// control events sent before the close
+				for e := range l.ch.ctlch {
+					l.drainQueue()
+					l.handle(&e)
+				}
+				l.drainRing()
+				l.flushRepeats()
+				l.flushBatch()
+				if l.ch.reopen != nil {
+					l.ch.reopen.Stop()
+				}
+				l.stopSync()
+				return
+			}
+
+		case err := <-l.ch.rotfail:
+			l.flushBatch()
+			l.rotateFailed(err)
+			continue
+
+		case <-l.ch.dd.expired():
+			l.flushRepeats()
+			l.flush()
+			continue
+
+		case <-l.reopenTick():
+			l.flushBatch()
+			l.reopenLog()
+			continue
+
+		case <-l.syncTick():
+			l.flushBatch()
+			l.fsync()
+			continue
+
+		case <-l.ringWake():
+			l.drainRing()
+			continue
+
+		case e, ok = <-ctl:
+			if !ok {
+				ctl = nil
+				continue
+			}
+
+			// the lines queued before a control event must be
+			// handled first: those in the queue and then those
+			// in the ring.
+			l.drainQueue()
+			l.drainRing()
+		}
+
+		l.handle(&e)
+	}
+}
+
+// handle the log lines that are in the queue now; lines queued later are
+// left for qrunner. Called only from qrunner.
+func (l *xLogger) drainQueue() {
+	for n := len(l.ch.logch); n > 0; n-- {
+		e, ok := <-l.ch.logch
+		if !ok {
+			return
+		}
+		l.handle(&e)
+	}
+}
+
+// handle 'e' in the caller's goroutine: synchronous loggers have no
+// qrunner. Pending rotation failures and checks of the log file are
+// handled along with the next event.
+func (l *xLogger) handleSync(e *qev) {
+	l.ch.smu.Lock()
+	defer l.ch.smu.Unlock()
+
+	// we may have lost the race with Close()
+	if l.ch.closed.Load() {
+		if e.ty == _QEV_LOG {
 			l.putBuf(e.buf)
+		}
+		if e.done != nil {
+			e.done <- fmt.Errorf("%s: logger is closed", l.Prefix())
+		}
+		return
+	}
 
-		case _QEV_TIMER:
-			if 0 != (l.flag & lRotate) {
-				l.rotateLog()
+	select {
+	case err := <-l.ch.rotfail:
+		l.flushBatch()
+		l.rotateFailed(err)
+	case <-l.reopenTick():
+		l.flushBatch()
+		l.reopenLog()
+	case <-l.syncTick():
+		l.flushBatch()
+		l.fsync()
+	default:
+	}
 
-				// reset the counter so the first log message has full time stamp.
-				l.relstart.Store(false)
+	l.handle(e)
+}
 
-				l.dprintf(0, LOG_INFO, "Log rotation complete. Next rotate in +24 hours.")
-				time.AfterFunc(24*time.Hour, l.qtimer)
+// handle a single event from the queue; a panic while handling it (e.g.,
+// in a buggy io.Writer) is reported on stderr and doesn't kill qrunner.
+func (l *xLogger) handle(e *qev) {
+	defer func() {
+		if r := recover(); r != nil {
+			err := fmt.Errorf("logger: recovered from panic: %v", r)
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			l.ioError(err)
+			if e.done != nil {
+				e.done <- err
 			}
-		default:
-			l.dprintf(0, LOG_ERR, "logger: unknown event type %d in qrunner", e.ty)
 		}
+	}()
+
+	if l.ch.stale.Load() && l.ch.stale.Swap(false) {
+		l.flushRepeats()
+		l.flushBatch()
+		l.reopenLog()
+	}
+
+	if e.ty != _QEV_LOG {
+		l.flushRepeats()
+		l.flushBatch()
+	}
+
+	switch e.ty {
+	case _QEV_LOG:
+		if d := e.dfr; d != nil {
+			*e = d.l.ofmt(d.t, d.cs, 0, d.prio, d.s, d.v...)
+		}
+		if len(e.buf) == 0 || l.repeated(e) {
+			l.putBuf(e.buf)
+			return
+		}
+
+		l.emit(e, l.ch.batch)
+		l.putBuf(e.buf)
+
+		if fl := Priority(l.ch.flvl.Load()); fl > LOG_NONE && e.prio >= fl {
+			l.flush()
+			if l.ch.fsync != nil {
+				l.fsync()
+			}
+		}
+
+	case _QEV_TIMER:
+		if 0 != (l.Flags() & lRotate) {
+			l.rotateLog()
+
+			// reset the counter so the first log message has full time stamp.
+			l.ch.relbase = false
+
+			l.mu.Lock()
+			x := l.scheduleRotation()
+			l.mu.Unlock()
+
+			l.dprintf(0, LOG_INFO, "Log rotation complete. Next rotation at %s.", x.Format(time.RFC822Z))
+		}
+
+	case _QEV_ROTATE:
+		err := l.rotateLog()
+		if err == nil {
+			l.ch.relbase = false
+			l.dprintf(0, LOG_INFO, "Log rotation complete.")
+		}
+		if e.done != nil {
+			e.done <- err
+		}
+
+	case _QEV_REOPEN:
+		l.resetReopen()
+
+	case _QEV_FSYNC:
+		l.resetSync()
+
+	case _QEV_FLUSH:
+		l.flush()
+		if l.ch.fsync != nil {
+			l.fsync()
+		}
+		if e.done != nil {
+			e.done <- nil
+		}
+
+	case _QEV_SYNC:
+		l.flush()
+		err := l.syncFile()
+		if e.done != nil {
+			e.done <- err
+		}
+
+	default:
+		l.dprintf(0, LOG_ERR, "logger: unknown event type %d in qrunner", e.ty)
+	}
+
+	// flush buffered writers once the queue is momentarily empty
+	if len(l.ch.logch) == 0 && l.ringLen() == 0 {
+		l.flush()
 	}
 }
 
@@ -779,89 +2238,167 @@ func (l *xLogger) getBuf() []byte {
 }
 
 func (l *xLogger) putBuf(b []byte) {
+	// don't pin large allocations
+	if cap(b) > _LOGBUFGROW*l.ch.bufsz {
+		return
+	}
 	l.ch.pool.Put(b[:0])
 }
 
-// Rotate current file out
-func (l *xLogger) rotateLog() {
-	var gfd *gzip.Writer
-	var wfd *os.File
+// Rotate current file out: the live file is renamed and a fresh file is
+// opened in its place. The renamed file is compressed in the background
+// so that logging isn't blocked for the duration of the compression.
+func (l *xLogger) rotateLog() error {
+	var nfd *os.File
 	var err error
 	var errstr string
-	var gz, gztmp string
+	var tmp string
+	var prev chan struct{}
 
 	fd, ok := l.out.(*os.File)
 	if !ok {
 		panic("logger: rotatelog wants a file - but seems to be corrupted")
 	}
 
-	errf := func(err error, s string, args ...interface{}) string {
-		s = fmt.Sprintf("logger %s: logrotate: %s", l.prefix, s)
-		s = fmt.Sprintf(s, args...)
-		s = fmt.Sprintf("%s: %s", s, err)
-		return s
-	}
+	l.mu.Lock()
+	rc := l.rotcfg
+	now := l.now().In(rc.Location)
+	l.mu.Unlock()
 
 	if err = fd.Sync(); err != nil {
-		errstr = errf(err, "%s flush", l.name)
+		errstr = l.rotErr(err, "%s flush", l.name)
 		goto fail
 	}
 
-	if _, err = fd.Seek(0, 0); err != nil {
-		errstr = errf(err, "%s seek0 to start rotation", l.name)
+	// move the current file out of the way; it is compressed later
+	tmp = fmt.Sprintf("%s.%x", l.name, rand64())
+	if err = os.Rename(l.name, tmp); err != nil {
+		errstr = l.rotErr(err, "%s to %s rename", l.name, tmp)
 		goto fail
 	}
 
-	// First rotate the older files
-	if err = rotatefile(l.name, l.rot_n); err != nil {
-		errstr = errf(err, "rotate")
+	// the rotated out file has all the logs - compress it regardless
+	// of what happens next.
+	prev, l.ch.clast = l.ch.clast, make(chan struct{})
+	l.ch.cwg.Add(1)
+	go l.compressLog(tmp, &rc, now, prev, l.ch.clast)
+
+	if nfd, err = openFile(l.name, os.O_WRONLY|os.O_CREATE|os.O_APPEND|os.O_TRUNC|l.syncFlag(), 0600); err != nil {
+		errstr = l.rotErr(err, "%s create", l.name)
 		goto fail
 	}
 
-	// Now, compress the current file and store it
-	gz = fmt.Sprintf("%s.0.gz", l.name)
+	fd.Close()
+	l.setOut(nfd)
+	l.staleClones()
+	return nil
+
+fail:
+	err = errors.New(errstr)
+	l.rotateFailed(err)
+
+	// the hook mustn't run on qrunner
+	l.ch.cwg.Add(1)
+	go func() {
+		defer l.ch.cwg.Done()
+		l.rotated("", err)
+	}()
+	return err
+}
+
+// compress the rotated out log file 'tmp' into NAME.0.gz after rotating
+// the older compressed logs. This runs in its own goroutine. Rotation is
+// transactional: the older logs are rotated only after the compressed
+// log is safely on disk, and 'tmp' is removed only after the compressed
+// log is renamed into place. On failure, 'tmp' and the older logs are
+// left untouched. The compression starts after 'prev' (the previous
+// compression, if any) is done and closes 'done' when it is done.
+func (l *xLogger) compressLog(tmp string, rc *RotateConfig, now time.Time, prev, done chan struct{}) {
+	var gfd *gzip.Writer
+	var rfd, wfd *os.File
+	var err error
+	var errstr string
+	var gz, gztmp string
+
+	defer l.ch.cwg.Done()
+	defer close(done)
+
+	// back to back rotations must not step on each other: compress in
+	// the order of the rotations.
+	if prev != nil {
+		<-prev
+	}
+
+	if rfd, err = os.Open(tmp); err != nil {
+		errstr = l.rotErr(err, "%s open", tmp)
+		goto fail
+	}
+
+	// First, compress the rotated file
 	gztmp = fmt.Sprintf("%s.%x", l.name, rand64())
 
-	if wfd, err = os.OpenFile(gztmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644); err != nil {
-		errstr = errf(err, "% create", gztmp)
+	if wfd, err = openFile(gztmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644); err != nil {
+		errstr = l.rotErr(err, "%s create", gztmp)
 		goto fail
 	}
 
-	if gfd, err = gzip.NewWriterLevel(wfd, 9); err != nil {
-		errstr = errf(err, "%s gzip", gztmp)
+	if gfd, err = gzip.NewWriterLevel(wfd, rc.Level); err != nil {
+		errstr = l.rotErr(err, "%s gzip", gztmp)
 		goto fail1
 	}
 
-	if _, err = io.Copy(gfd, fd); err != nil {
-		errstr = errf(err, "%s gzip copy", gztmp)
+	if _, err = gzCopy(gfd, rfd); err != nil {
+		errstr = l.rotErr(err, "%s gzip copy", gztmp)
 		goto fail1
 	}
 
 	if err = gfd.Close(); err != nil {
-		errstr = errf(err, "%s gzip close", gztmp)
+		errstr = l.rotErr(err, "%s gzip close", gztmp)
+		goto fail1
+	}
+
+	if err = wfd.Sync(); err != nil {
+		errstr = l.rotErr(err, "%s sync", gztmp)
 		goto fail1
 	}
 
 	if err = wfd.Close(); err != nil {
-		errstr = errf(err, "%s close", gztmp)
+		errstr = l.rotErr(err, "%s close", gztmp)
 		goto fail2
 	}
 
-	if err = os.Rename(gztmp, gz); err != nil {
-		errstr = errf(err, "%s to %s rename", gztmp, gz)
+	// Now rotate the older files and store the compressed file
+	if rc.Dated {
+		if gz, err = datedName(l.name, now); err == nil {
+			err = pruneDated(l.name, rc.Keep-1)
+		}
+	} else {
+		gz, err = fmt.Sprintf("%s.0.gz", l.name), rotatefile(l.name, rc.Keep)
+	}
+	if err != nil {
+		errstr = l.rotErr(err, "rotate")
 		goto fail2
 	}
 
-	if err = fd.Truncate(0); err != nil {
-		errstr = errf(err, "%s truncate", l.name)
-		goto fail
+	if err = os.Rename(gztmp, gz); err != nil {
+		errstr = l.rotErr(err, "%s to %s rename", gztmp, gz)
+		goto fail2
 	}
 
-	if _, err = fd.Seek(0, 0); err != nil {
-		errstr = errf(err, "%s seek0", l.name)
-		goto fail
+	rfd.Close()
+
+	// the rotated log is safe; a stale copy left behind is harmless
+	if err = os.Remove(tmp); err != nil {
+		l.ioError(errors.New(l.rotErr(err, "%s rm", tmp)))
 	}
 
+	// failure to expire old logs doesn't affect the live log
+	if rc.RetainFor > 0 {
+		if err = pruneAge(l.name, now.Add(-rc.RetainFor)); err != nil {
+			l.ioError(errors.New(l.rotErr(err, "expire")))
+		}
+	}
+	l.rotated(gz, nil)
 	return
 
 fail1:
@@ -870,22 +2407,71 @@ fail1:
 fail2:
 	os.Remove(gztmp)
 
-	// When all else fails - start to log to stderr - hopefully daemons started by
-	// supervisory regimes will redirect the log messages to syslog or some other place.
 fail:
-	fd.Close()
-	l.out = os.Stderr
-	l.Error(errstr)
-	l.Error("switching to STDERR for future logs ..")
-	l.flag &= ^lClose
-	return
+	if rfd != nil {
+		rfd.Close()
+	}
+
+	// qrunner owns the output; let it handle the failure.
+	err = errors.New(errstr)
+	select {
+	case l.ch.rotfail <- err:
+	default:
+	}
+	l.rotated("", err)
+}
+
+// gzCopy copies the rotated log into the compressor; it's a variable
+// so tests can slow it down.
+var gzCopy = io.Copy
+
+// openFile creates files during log rotation; it's a variable so tests
+// can make it fail.
+var openFile = os.OpenFile
+
+// make an error string for log rotation failures
+func (l *xLogger) rotErr(err error, s string, args ...interface{}) string {
+	s = fmt.Sprintf(s, args...)
+	return fmt.Sprintf("logger %s: logrotate: %s: %s", l.Prefix(), s, err)
+}
+
+// When all else fails - start to log to stderr - hopefully daemons started by
+// supervisory regimes will redirect the log messages to syslog or some other place.
+// This must only be called from qrunner.
+func (l *xLogger) rotateFailed(err error) {
+	if fd, ok := l.out.(*os.File); ok && fd != os.Stderr {
+		fd.Close()
+	}
+
+	l.setOut(os.Stderr)
+
+	l.mu.Lock()
+	l.flag &= ^(lClose | lRotate)
+	l.mu.Unlock()
+
+	// we're in qrunner; we can't use the queue.
+	l.dprintf(0, LOG_ERR, "%s", err)
+	l.dprintf(0, LOG_ERR, "switching to STDERR for future logs ..")
+
+	l.ioError(err)
 }
 
 // Cheap integer to fixed-width decimal ASCII.  Give a negative width to avoid zero-padding.
+// Negative numbers are written as '-' followed by the zero-padded absolute value; widths
+// beyond the size of the scratch buffer are clamped.
 func itoa(out []byte, i int, wid int) []byte {
-	var u uint = uint(i)
 	var b [32]byte
 
+	u := uint(i)
+	if i < 0 {
+		u = -u
+	}
+
+	// leave room for the sign
+	if wid > len(b)-1 {
+		wid = len(b) - 1
+	}
+
 	bp := len(b) - 1
 	for u >= 10 || wid > 1 {
 		wid--
@@ -896,12 +2482,16 @@ func itoa(out []byte, i int, wid int) []byte {
 	}
 	// u < 10
 	b[bp] = byte('0' + u)
+	if i < 0 {
+		bp--
+		b[bp] = '-'
+	}
 	return append(out, b[bp:]...)
 }
 
 // make a printable timestamp out of 't' using the flags 'fl'
 func timestamp(out []byte, t time.Time, fl int) []byte {
-	if fl&(Ldate|Ltime|Lmicroseconds) == 0 {
+	if fl&(Ldate|Ltime|Lmicroseconds|Lnanoseconds) == 0 {
 		return out
 	}
 
@@ -917,11 +2507,11 @@ func timestamp(out []byte, t time.Time, fl int) []byte {
 		date = true
 	}
 
-	if fl&(Ltime|Lmicroseconds) != 0 {
+	if fl&(Ltime|Lmicroseconds|Lnanoseconds) != 0 {
 		hour, min, sec := t.Clock()
 
-		// this is now the microsec offset within the second
-		microsecs := t.Nanosecond() / 1000
+		// this is now the nanosec offset within the second
+		nsecs := t.Nanosecond()
 
 		if date {
 			out = append(out, ' ')
@@ -934,10 +2524,13 @@ func timestamp(out []byte, t time.Time, fl int) []byte {
 		out = itoa(out, sec, 2)
 		out = append(out, '.')
 
-		if fl&Lmicroseconds != 0 {
-			out = itoa(out, microsecs, 6)
-		} else {
-			out = itoa(out, microsecs/1000, 3)
+		switch {
+		case fl&Lnanoseconds != 0:
+			out = itoa(out, nsecs, 9)
+		case fl&Lmicroseconds != 0:
+			out = itoa(out, nsecs/1000, 6)
+		default:
+			out = itoa(out, nsecs/1000000, 3)
 		}
 	}
 	return out
@@ -993,19 +2586,45 @@ func rand64() uint64 {
 	return binary.BigEndian.Uint64(b[:])
 }
 
-// fetch backtrace info to 'depth' callers
-func backTrace(depth, flag int) string {
+// return the name of the function containing 'pc'; the package path is
+// trimmed unless Lfullpath is set.
+func funcName(pc uintptr, ok bool, flag int) string {
+	var fn *runtime.Func
+
+	if ok {
+		fn = runtime.FuncForPC(pc)
+	}
+	if fn == nil {
+		return "???"
+	}
+
+	name := fn.Name()
+	if (flag & Lfullpath) == 0 {
+		// pkg/path.(*Type).method -> (*Type).method
+		if i := strings.LastIndexByte(name, '/'); i >= 0 {
+			name = name[i+1:]
+		}
+		if i := strings.IndexByte(name, '.'); i >= 0 {
+			name = name[i+1:]
+		}
+	}
+	return name
+}
+
+// fetch backtrace info to 'depth' callers; 'skip' is the number of
+// additional frames to skip above the caller of backTrace().
+func backTrace(skip, depth, flag int) string {
 	var wr strings.Builder
-	var pcv [64]uintptr
+	var pcv [_MAX_BACKTRACE]uintptr
 
 	// runtime.Callers() requires a pre-created array.
-	n := runtime.Callers(3, pcv[:])
+	n := runtime.Callers(3+skip, pcv[:])
 	if n == 0 {
 		wr.WriteString("no backtrace frames!")
 		return wr.String()
 	}
 
-	if depth == 0 {
+	if depth <= 0 {
 		depth = n
 	}
 
@@ -2,8 +2,19 @@ package logger
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"log/syslog"
+	"math"
+	"os"
+	"path/filepath"
 	re "regexp"
+	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -13,6 +24,7 @@ import (
 
 const (
 	_Rprio      = `<(?<prio>[0-9]+)>:`
+	_Rlevel     = `(?<level>[A-Z]+):`
 	_Rdate      = `(?<date>[0-9][0-9][0-9][0-9]/[0-9][0-9]/[0-9][0-9])`
 	_Rtime      = `(?<time>[0-9][0-9]:[0-9][0-9]:[0-9][0-9].(?<frac>[0-9]+))`
 	_Rline      = `(?<line>[0-9][0-9]*)`
@@ -38,8 +50,12 @@ var tests = []testcase{
 	{Ldate, "foo", "date", _Rprio + _Rdate + _Rspace + _Rprefix + _Rlogmsg},
 	{Ltime, "foo", "time", _Rprio + _Rtime + _Rspace + _Rprefix + _Rlogmsg},
 	{Ltime | Lmicroseconds, "foo", "time+us", _Rprio + _Rtime + _Rspace + _Rprefix + _Rlogmsg},
-	{Ldate | Ltime | Lfileloc, "foo", "file trace", _Rprio + _Rdate + _Rspace + _Rtime + _Rspace + _Rprefix + _Rlogmsg},
+	{Lmicroseconds, "foo", "us only", _Rprio + _Rtime + _Rspace + _Rprefix + _Rlogmsg},
+	{Lnanoseconds, "foo", "ns only", _Rprio + _Rtime + _Rspace + _Rprefix + _Rlogmsg},
+	{Lmicroseconds | Lnanoseconds, "foo", "ns over us", _Rprio + _Rtime + _Rspace + _Rprefix + _Rlogmsg},
+	{Ldate | Ltime | Lfileloc, "foo", "file trace", _Rprio + _Rdate + _Rspace + _Rtime + _Rspace + _Rprefix + _Rshortfile + _Rspace + _Rlogmsg},
 	{Lreltime, "foo", "reltime", _Rprio + _Rreltime + _Rspace + _Rprefix + _Rlogmsg},
+	{Llevelname | Ldate | Ltime, "foo", "level name", _Rlevel + _Rdate + _Rspace + _Rtime + _Rspace + _Rprefix + _Rlogmsg},
 }
 
 func makeSubMap(rx *re.Regexp, s string) (map[string]string, error) {
@@ -86,6 +102,9 @@ func doTest(t *testing.T, tc *testcase) {
 			want := fmt.Sprintf("%d", LOG_INFO)
 			assert(want == m["prio"], "match: prio: exp %s, saw %s", want, m["prio"])
 
+		case "level":
+			assert(v == "INFO", "match: level: exp INFO, saw %s", v)
+
 		case "date":
 			if tc.flag == 0 || tc.flag&Ldate > 0 {
 				assert(len(v) > 0, "match: date: exp value; saw nil")
@@ -94,11 +113,13 @@ func doTest(t *testing.T, tc *testcase) {
 			}
 
 		case "time":
-			if tc.flag == 0 || tc.flag&Ltime > 0 {
+			if tc.flag == 0 || tc.flag&(Ltime|Lmicroseconds|Lnanoseconds) > 0 {
 				frac := m["frac"]
 				assert(len(v) > 0, "match: time: exp value; saw nil")
 				assert(len(frac) >= 3, "match: time: frac explen min 3, saw %d", len(frac))
-				if tc.flag&Lmicroseconds > 0 {
+				if tc.flag&Lnanoseconds > 0 {
+					assert(len(frac) == 9, "match: time ns frac: explen 9, saw %d", len(frac))
+				} else if tc.flag&Lmicroseconds > 0 {
 					assert(len(frac) == 6, "match: time us frac: explen 6, saw %d", len(frac))
 				}
 			} else {
@@ -147,6 +168,43 @@ func TestLog(t *testing.T) {
 	}
 }
 
+func TestMicrosecondsOnly(t *testing.T) {
+	assert := newAsserter(t, "us-only")
+	var wr bytes.Buffer
+
+	ll, err := New(&wr, LOG_INFO, "", Lmicroseconds)
+	assert(err == nil, "can't make logger: %s", err)
+
+	assert(ll.(*xLogger).Flags()&Ltime != 0, "Lmicroseconds doesn't imply Ltime")
+	ll.Info("hello")
+	ll.Close()
+
+	rx := re.MustCompile(`(?m)^<2>:\d\d:\d\d:\d\d\.\d{6} hello$`)
+	assert(rx.MatchString(wr.String()), "bad timestamp:\n%s", wr.String())
+}
+
+func TestRelSeconds(t *testing.T) {
+	assert := newAsserter(t, "relseconds")
+	var wr bytes.Buffer
+
+	ll, err := New(&wr, LOG_INFO, "", Lrelseconds)
+	assert(err == nil, "can't make logger: %s", err)
+
+	x := ll.(*xLogger)
+	assert(x.Flags()&Lreltime != 0, "Lrelseconds doesn't imply Lreltime")
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	x.SetClock(func() time.Time { return now })
+	now = now.Add(time.Hour + 2*time.Minute + 3*time.Second + 456789*time.Microsecond)
+	ll.Info("later")
+	ll.Close()
+
+	assert(strings.Contains(wr.String(), "<2>:+3723.456789000 later\n"), "bad reltime:\n%s", wr.String())
+
+	rx := re.MustCompile(`(?m)^<2>:\+\d+\.\d{9} later$`)
+	assert(rx.MatchString(wr.String()), "not decimal seconds:\n%s", wr.String())
+}
+
 func TestConcurrent(t *testing.T) {
 	const maxG int = 5000
 	assert := newAsserter(t, "")
@@ -181,8 +239,2279 @@ func TestConcurrent(t *testing.T) {
 	exp := uint64(strings.Count(logs, "\n"))
 
 	// the first and last lines are from the logger itself.
-	// XXX We don't have a test for log rotation.
 	saw := 2 + c.Load()
 
 	assert(exp == saw, "log lines: exp %d, saw %d", exp, saw)
 }
+
+func TestFatal(t *testing.T) {
+	assert := newAsserter(t, "fatal")
+	var wr bytes.Buffer
+
+	code := -1
+	SetExitFunc(func(c int) {
+		// by the time we exit, all the logs must've been flushed
+		assert(strings.Contains(wr.String(), "fatal-error 42"), "log line not flushed")
+		assert(strings.Contains(wr.String(), "--backtrace:"), "backtrace not flushed")
+		code = c
+	})
+	defer SetExitFunc(nil)
+
+	ll, err := New(&wr, LOG_INFO, "fatal", 0)
+	assert(err == nil, "can't create log: %s", err)
+
+	ll.Fatal("fatal-error %d", 42)
+	assert(code == 1, "exit code: exp 1, saw %d", code)
+}
+
+func nestedPanic(l Logger, n int) {
+	if n == 0 {
+		l.(*xLogger).Panic("nested panic")
+	}
+	nestedPanic(l, n-1)
+}
+
+func TestPanicDepth(t *testing.T) {
+	assert := newAsserter(t, "panic-depth")
+	rx := re.MustCompile(`(?m)^\t\s*[0-9]+: `)
+
+	frames := func(depth int) int {
+		var wr bytes.Buffer
+
+		ll, err := New(&wr, LOG_INFO, "", 0)
+		assert(err == nil, "can't create log: %s", err)
+		ll.(*xLogger).SetPanicDepth(depth)
+
+		func() {
+			defer func() {
+				r := recover()
+				assert(r != nil, "expected panic")
+			}()
+			nestedPanic(ll, 10)
+		}()
+		return len(rx.FindAllString(wr.String(), -1))
+	}
+
+	n := frames(_PANIC_BACKTRACES)
+	assert(n == _PANIC_BACKTRACES, "default: exp %d frames, saw %d", _PANIC_BACKTRACES, n)
+
+	n = frames(3)
+	assert(n == 3, "depth 3: exp 3 frames, saw %d", n)
+
+	n = frames(0)
+	assert(n > 10, "full: exp > 10 frames, saw %d", n)
+
+	n = frames(1000)
+	assert(n <= _MAX_BACKTRACE, "clamp: exp <= %d frames, saw %d", _MAX_BACKTRACE, n)
+}
+
+func TestStackTrace(t *testing.T) {
+	assert := newAsserter(t, "stacktrace")
+
+	ll, err := New(&nullWriter{}, LOG_INFO, "", 0)
+	assert(err == nil, "can't create log: %s", err)
+	defer ll.Close()
+
+	s := ll.StackTrace(0)
+	assert(strings.Contains(s, "TestStackTrace"), "missing caller in backtrace:\n%s", s)
+
+	nl := NewNoneLogger(LOG_INFO, "")
+	assert(nl.StackTrace(0) == "", "null logger: exp empty backtrace")
+}
+
+func logError(ll Logger, msg string) {
+	ll.Error("%s", msg)
+}
+
+func TestErrorBacktrace(t *testing.T) {
+	assert := newAsserter(t, "error-backtrace")
+	var wr bytes.Buffer
+
+	ll, err := New(&wr, LOG_INFO, "", Lfileloc)
+	assert(err == nil, "can't create log: %s", err)
+
+	ll.Error("quiet")
+	ll.(*xLogger).SetErrorBacktrace(2)
+	logError(ll, "loud")
+	ll.Warn("no trace")
+	ll.Close()
+
+	out := wr.String()
+	i := strings.Index(out, "quiet\n")
+	assert(i > 0 && !strings.HasPrefix(out[i+6:], "--backtrace:"), "backtrace when disabled:\n%s", out)
+
+	rx := re.MustCompile(`\(logger_test\.go:\d+\) loud\n--backtrace:\n\t 1: .*TestErrorBacktrace.*\n\t 0: .*\n--end backtrace\n`)
+	assert(rx.MatchString(out), "missing backtrace:\n%s", out)
+
+	// the caller is already logged via Lfileloc
+	assert(!strings.Contains(out, "logError +"), "caller in backtrace:\n%s", out)
+	assert(strings.Count(out, "--backtrace:") == 1, "exp 1 backtrace:\n%s", out)
+}
+
+func TestFuncName(t *testing.T) {
+	assert := newAsserter(t, "funcname")
+	var wr bytes.Buffer
+
+	ll, err := New(&wr, LOG_INFO, "", Lfileloc|Lfunc)
+	assert(err == nil, "can't create log: %s", err)
+
+	ll.Error("where am i")
+	ll.Close()
+
+	rx := re.MustCompile(`\(logger_test\.go:[0-9]+ TestFuncName\) where am i`)
+	assert(rx.MatchString(wr.String()), "missing func name:\n%s", wr.String())
+
+	wr.Reset()
+	ll, err = New(&wr, LOG_INFO, "", Lfullpath|Lfunc)
+	assert(err == nil, "can't create log: %s", err)
+
+	ll.Error("where am i")
+	ll.Close()
+
+	rx = re.MustCompile(`/logger_test\.go:[0-9]+ github.com/opencoff/go-logger\.TestFuncName\) where am i`)
+	assert(rx.MatchString(wr.String()), "missing full func name:\n%s", wr.String())
+}
+
+func TestFilelocLevels(t *testing.T) {
+	assert := newAsserter(t, "fileloc")
+	var wr bytes.Buffer
+
+	ll, err := New(&wr, LOG_DEBUG, "", Lfileloc)
+	assert(err == nil, "can't create log: %s", err)
+
+	ll.Debug("debug")
+	ll.Info("info")
+	ll.Warn("warn")
+	ll.Error("error")
+	ll.Crit("crit")
+	ll.Close()
+
+	for _, lvl := range []string{"debug", "info", "warn", "error", "crit"} {
+		rx := re.MustCompile(_Rshortfile + ` ` + lvl + `\n`)
+		m, err := makeSubMap(rx, wr.String())
+		assert(err == nil, "%s: no file location:\n%s", lvl, wr.String())
+		assert(m["fname"] == "logger_test.go", "%s: wrong file %s", lvl, m["fname"])
+	}
+}
+
+func TestSetPrefix(t *testing.T) {
+	assert := newAsserter(t, "set-prefix")
+	var wr bytes.Buffer
+
+	ll, err := New(&wr, LOG_INFO, "old", 0)
+	assert(err == nil, "can't create log: %s", err)
+
+	xl := ll.(*xLogger)
+	ll.Info("before")
+	xl.SetPrefix("node-7")
+	ll.Info("after")
+	ll.StdLogger().Print("stdlib")
+
+	xl.SetFlags(Lfileloc)
+	assert(xl.Flags()&lPrefix != 0, "SetFlags cleared internal flags")
+	ll.Close()
+
+	out := wr.String()
+	assert(strings.Contains(out, "[old] before"), "missing old prefix:\n%s", out)
+	assert(strings.Contains(out, "[node-7] after"), "missing new prefix:\n%s", out)
+	assert(re.MustCompile(`\[node-7\] .* stdlib`).MatchString(out), "stdlogger: missing new prefix:\n%s", out)
+	assert(ll.Prefix() == "[node-7] ", "prefix: saw %q", ll.Prefix())
+}
+
+func TestWithFields(t *testing.T) {
+	assert := newAsserter(t, "with-fields")
+	var wr bytes.Buffer
+
+	ll, err := New(&wr, LOG_INFO, "", 0)
+	assert(err == nil, "can't create log: %s", err)
+
+	fl := ll.WithFields(map[string]any{"req": "abc"})
+	fl.Info("hello")
+
+	f2 := fl.WithFields(map[string]any{"user": "joe smith", "n": 3})
+	f2.Info("chained\n")
+	ll.Info("plain")
+	ll.Close()
+
+	out := wr.String()
+	assert(strings.Contains(out, "hello req=abc\n"), "missing fields:\n%s", out)
+	assert(strings.Contains(out, `chained n=3 req=abc user="joe smith"`+"\n"), "missing chained fields:\n%s", out)
+	assert(strings.Contains(out, "plain\n"), "parent has fields:\n%s", out)
+}
+
+func TestInfow(t *testing.T) {
+	assert := newAsserter(t, "infow")
+	var wr bytes.Buffer
+
+	ll, err := New(&wr, LOG_INFO, "", Lfileloc)
+	assert(err == nil, "can't create log: %s", err)
+
+	ll.Infow("done", "count", 3, "ok", true)
+	ll.WithFields(map[string]any{"req": "abc", "ok": "maybe"}).Warnw("partial", "ok", false)
+	ll.Errorw("odd", "count", 1, "dangling")
+	ll.Close()
+
+	out := wr.String()
+	assert(re.MustCompile(`\(logger_test\.go:\d+\) done count=3 ok=true\n`).MatchString(out), "missing pairs:\n%s", out)
+	assert(strings.Contains(out, "partial req=abc ok=false\n"), "fields not merged:\n%s", out)
+	assert(strings.Contains(out, "odd count=1 !BADKEY=dangling\n"), "odd kv mishandled:\n%s", out)
+}
+
+func TestPrint(t *testing.T) {
+	assert := newAsserter(t, "print")
+
+	args := [][]any{
+		{"a", "b"},
+		{1, 2, "x", 3.5},
+		{"x", 1, 2, "y"},
+		{errors.New("oops"), nil, true},
+	}
+
+	for i, v := range args {
+		var b, sb bytes.Buffer
+
+		ll, err := NewWithOptions(&b, LOG_INFO, "", Llevelname, &Options{Quiet: true})
+		assert(err == nil, "can't create log: %s", err)
+		std := log.New(&sb, "", 0)
+
+		ll.Print(v...)
+		std.Print(v...)
+		ll.Println(v...)
+		std.Println(v...)
+		ll.Printf("%v|%d", v[0], len(v))
+		std.Printf("%v|%d", v[0], len(v))
+		ll.Close()
+
+		// each call writes one line
+		var exp strings.Builder
+		for _, s := range strings.SplitAfter(sb.String(), "\n") {
+			if len(s) > 0 {
+				exp.WriteString("INFO: " + s)
+			}
+		}
+		assert(b.String() == exp.String(), "%d: exp:\n%s\nsaw:\n%s", i, exp.String(), b.String())
+	}
+}
+
+func TestLevelWriter(t *testing.T) {
+	assert := newAsserter(t, "levelwriter")
+
+	var b bytes.Buffer
+	ll, err := NewWithOptions(&b, LOG_WARN, "", Llevelname, &Options{Quiet: true})
+	assert(err == nil, "can't create log: %s", err)
+
+	errlog := log.New(ll.LevelWriter(LOG_ERR), "http: ", 0)
+	errlog.Printf("TLS handshake error from %s", "10.1.2.3:4567")
+	log.New(ll.LevelWriter(LOG_INFO), "", 0).Print("not logged")
+	ll.Close()
+
+	exp := "ERROR: http: TLS handshake error from 10.1.2.3:4567\n"
+	assert(b.String() == exp, "exp %q, saw %q", exp, b.String())
+}
+
+// a logging facade around the logger
+func logVia(ll Logger, msg string) {
+	ll.Info("%s", msg)
+}
+
+func TestCallerSkip(t *testing.T) {
+	assert := newAsserter(t, "callerskip")
+
+	var b bytes.Buffer
+	ll, err := NewWithOptions(&b, LOG_INFO, "", Lfileloc, &Options{Quiet: true})
+	assert(err == nil, "can't create log: %s", err)
+
+	ll.(*xLogger).SetCallerSkip(1)
+	_, _, line, _ := runtime.Caller(0)
+	logVia(ll, "wrapped")
+	logVia(ll.New("sub", 0), "sub")
+	ll.Close()
+
+	out := b.String()
+	for i, m := range []string{"wrapped", "sub"} {
+		exp := fmt.Sprintf("(logger_test.go:%d) %s\n", line+1+i, m)
+		assert(strings.Contains(out, exp), "exp %q:\n%s", exp, out)
+	}
+}
+
+func TestDropEmpty(t *testing.T) {
+	assert := newAsserter(t, "dropempty")
+
+	var b bytes.Buffer
+	ll, err := NewWithOptions(&b, LOG_INFO, "", 0, &Options{Quiet: true})
+	assert(err == nil, "can't create log: %s", err)
+
+	ll.Info("")
+	assert(ll.Flush() == nil, "flush failed")
+	assert(b.Len() == 0, "empty message not dropped: %q", b.String())
+
+	ll.(*xLogger).SetDropEmpty(false)
+	ll.Info("")
+	ll.Close()
+
+	rx := re.MustCompile(`^<2>:\d{4}/\d\d/\d\d \d\d:\d\d:\d\d\.\d+ \n$`)
+	assert(rx.MatchString(b.String()), "exp one blank line with a timestamp: %q", b.String())
+}
+
+func TestEvent(t *testing.T) {
+	assert := newAsserter(t, "event")
+	var wr bytes.Buffer
+
+	ll, err := New(&wr, LOG_INFO, "", Lfileloc)
+	assert(err == nil, "can't create log: %s", err)
+
+	ll.Event(LOG_WARN).Str("user", "bob smith").Int("n", 3).Msg("login")
+	ll.Event(LOG_ERR).Err(errors.New("boom")).Err(nil).Msg("failed")
+
+	e := ll.Event(LOG_DEBUG)
+	assert(e == nil, "disabled level made an entry")
+	e.Str("k", "v").Int("n", 1).Err(io.EOF).Msg("dropped")
+
+	n := testing.AllocsPerRun(100, func() {
+		ll.Event(LOG_DEBUG).Str("k", "v").Int("n", 1).Msg("dropped")
+	})
+	assert(n == 0, "disabled level allocates: %v", n)
+	ll.Close()
+
+	out := wr.String()
+	assert(re.MustCompile(`\(logger_test\.go:\d+\) login user="bob smith" n=3\n`).MatchString(out), "bad line:\n%s", out)
+	assert(strings.Contains(out, "failed error=boom\n"), "missing error:\n%s", out)
+	assert(!strings.Contains(out, "dropped"), "disabled level logged:\n%s", out)
+}
+
+func TestLineEnding(t *testing.T) {
+	assert := newAsserter(t, "line-ending")
+	var wr bytes.Buffer
+
+	ll, err := New(&wr, LOG_INFO, "", 0)
+	assert(err == nil, "can't create log: %s", err)
+
+	x := ll.(*xLogger)
+	x.SetLineEnding("\r\n")
+	x.SetDedup(time.Hour)
+	ll.Info("one")
+	ll.Info("one")
+	ll.WithFields(map[string]any{"k": "v"}).Info("two\n")
+	x.StdLogger().Print("std")
+	ll.Close()
+
+	out := wr.String()
+	lines := strings.SplitAfter(out, "\n")
+	lines = lines[1 : len(lines)-1]
+	assert(len(lines) == 5, "exp 5 lines; saw:\n%q", out)
+	for _, s := range lines {
+		assert(strings.HasSuffix(s, "\r\n"), "bad line ending: %q", s)
+		assert(!strings.HasSuffix(s, "\r\r\n"), "double line ending: %q", s)
+	}
+	assert(strings.Contains(out, "last message repeated 1 times\r\n"), "dedup summary:\n%q", out)
+	assert(strings.Contains(out, "two k=v\r\n"), "fields:\n%q", out)
+}
+
+func TestStdLoggerWrites(t *testing.T) {
+	assert := newAsserter(t, "stdlogger-writes")
+	var wr bytes.Buffer
+
+	ll, err := New(&wr, LOG_INFO, "", Llevelname)
+	assert(err == nil, "can't create log: %s", err)
+
+	// the stdlib logger reuses its line buffer across calls
+	const N, M = 8, 500
+	var wg sync.WaitGroup
+	for i := 0; i < N; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			std := ll.StdLogger()
+			for j := 0; j < M; j++ {
+				std.Printf("g%d-%d %s", i, j, strings.Repeat("x", j%64))
+			}
+		}(i)
+	}
+	wg.Wait()
+	ll.Close()
+
+	rx := re.MustCompile(`^g(\d+)-(\d+) (x*)$`)
+	seen := 0
+	for _, s := range strings.Split(wr.String(), "\n") {
+		if !strings.HasPrefix(s, "g") {
+			continue
+		}
+
+		m := rx.FindStringSubmatch(s)
+		assert(m != nil, "corrupt line: %q", s)
+
+		j, _ := strconv.Atoi(m[2])
+		assert(len(m[3]) == j%64, "corrupt line: %q", s)
+		seen++
+	}
+	assert(seen == N*M, "exp %d lines, saw %d", N*M, seen)
+}
+
+func TestDefault(t *testing.T) {
+	assert := newAsserter(t, "default")
+	var wr bytes.Buffer
+
+	ll, err := New(&wr, LOG_INFO, "def", Lfileloc)
+	assert(err == nil, "can't create log: %s", err)
+
+	old := defLog.Load()
+	SetDefault(ll)
+	defer defLog.Store(old)
+
+	Info("free %s", "info")
+	Debug("free debug")
+	assert(Default() == ll, "default logger not set")
+	ll.Close()
+
+	out := wr.String()
+	rx := re.MustCompile(`\[def\] \(logger_test\.go:[0-9]+\) free info`)
+	assert(rx.MatchString(out), "missing free function output:\n%s", out)
+	assert(!strings.Contains(out, "free debug"), "debug shouldn't be logged:\n%s", out)
+}
+
+func freeError(msg string) {
+	Error("%s", msg)
+}
+
+func TestDefaultErrorBacktrace(t *testing.T) {
+	assert := newAsserter(t, "default-backtrace")
+	var wr bytes.Buffer
+
+	ll, err := New(&wr, LOG_INFO, "", Lfileloc)
+	assert(err == nil, "can't create log: %s", err)
+
+	old := defLog.Load()
+	SetDefault(ll)
+	defer defLog.Store(old)
+
+	ll.(*xLogger).SetErrorBacktrace(2)
+	freeError("loud")
+	Crit("crit")
+	ll.Close()
+
+	out := wr.String()
+	rx := re.MustCompile(`\(logger_test\.go:\d+\) loud\n--backtrace:\n\t 1: .*TestDefaultErrorBacktrace.*\n\t 0: .*\n--end backtrace\n`)
+	assert(rx.MatchString(out), "missing backtrace:\n%s", out)
+	assert(!strings.Contains(out, "freeError +"), "caller in backtrace:\n%s", out)
+
+	rx = re.MustCompile(`\(logger_test\.go:\d+\) crit\n--backtrace:\n`)
+	assert(rx.MatchString(out), "missing crit backtrace:\n%s", out)
+}
+
+func TestClock(t *testing.T) {
+	assert := newAsserter(t, "clock")
+	var wr bytes.Buffer
+
+	ts := time.Date(2009, 1, 23, 1, 23, 23, 123456789, time.UTC)
+
+	ll, err := New(&wr, LOG_INFO, "foo", Ldate|Ltime|Lmicroseconds)
+	assert(err == nil, "can't create log: %s", err)
+
+	ll.(*xLogger).SetClock(func() time.Time { return ts })
+	ll.Info("hello")
+	ll.Close()
+
+	_, err = wr.ReadString('\n')
+	assert(err == nil, "read hdr string: %s", err)
+
+	out, err := wr.ReadString('\n')
+	assert(err == nil, "read string: %s", err)
+
+	exp := "<2>:2009/01/23 01:23:23.123456 [foo] hello\n"
+	assert(out == exp, "\nexp %q\nsaw %q", exp, out)
+}
+
+func TestOutputAt(t *testing.T) {
+	assert := newAsserter(t, "outputat")
+	var wr bytes.Buffer
+
+	start := time.Date(2024, 1, 23, 10, 0, 0, 0, time.UTC)
+	past := time.Date(2009, 1, 23, 1, 23, 23, 123456789, time.FixedZone("X", 3600))
+
+	ll, err := NewWithOptions(&wr, LOG_INFO, "foo", Ldate|Ltime|Lmicroseconds, &Options{Quiet: true})
+	assert(err == nil, "can't create log: %s", err)
+
+	x := ll.(*xLogger)
+	x.SetClock(func() time.Time { return start })
+	x.OutputAt(past, 1, LOG_WARN, "replayed %d", 1)
+	x.SetFlags(Lreltime)
+	x.OutputAt(start.Add(90*time.Second), 1, LOG_INFO, "first")
+	x.OutputAt(start.Add(150*time.Second), 1, LOG_INFO, "second")
+	ll.Close()
+
+	out := wr.String()
+	exp := "<4>:2009/01/23 00:23:23.123456 [foo] replayed 1\n"
+	assert(strings.HasPrefix(out, exp), "\nexp %q\nsaw %q", exp, out)
+	assert(strings.Contains(out, "<2>:2024/01/23 10:01:30"), "first relative line not absolute:\n%s", out)
+	assert(strings.Contains(out, "<2>:+2m30s [foo] second\n"), "wrong relative time:\n%s", out)
+}
+
+var errDiskFull = errors.New("disk full")
+
+// writer that always fails
+type errWriter struct{}
+
+func (e *errWriter) Write(b []byte) (int, error) {
+	return 0, errDiskFull
+}
+
+func TestErrorHandler(t *testing.T) {
+	assert := newAsserter(t, "error-handler")
+
+	ll, err := New(&errWriter{}, LOG_INFO, "", 0)
+	assert(err == nil, "can't create log: %s", err)
+
+	var n atomic.Int32
+	var saw atomic.Value
+	ll.(*xLogger).SetErrorHandler(func(err error) {
+		n.Add(1)
+		saw.Store(err)
+	})
+
+	ll.Info("this will fail")
+	ll.Close()
+
+	assert(n.Load() > 0, "error handler not called")
+	err, _ = saw.Load().(error)
+	assert(errors.Is(err, errDiskFull), "wrong error: %v", err)
+}
+
+func TestRateLimit(t *testing.T) {
+	assert := newAsserter(t, "ratelimit")
+	var wr bytes.Buffer
+
+	ll, err := New(&wr, LOG_INFO, "", 0)
+	assert(err == nil, "can't create log: %s", err)
+
+	now := time.Now()
+	xl := ll.(*xLogger)
+	xl.SetClock(func() time.Time { return now })
+	xl.SetRateLimit(10, time.Second)
+
+	for i := 0; i < 1000; i++ {
+		ll.Info("spam %d", i)
+	}
+
+	// the next window must summarize the drops
+	now = now.Add(time.Second)
+	ll.Info("next window")
+	ll.Close()
+
+	out := wr.String()
+	n := strings.Count(out, "spam ")
+	assert(n == 10, "exp 10 lines, saw %d", n)
+	assert(strings.Contains(out, "suppressed 990 messages"), "missing summary:\n%s", out)
+	assert(strings.Contains(out, "next window\n"), "missing line in next window:\n%s", out)
+}
+
+func TestSampler(t *testing.T) {
+	assert := newAsserter(t, "sampler")
+	var wr bytes.Buffer
+
+	ll, err := New(&wr, LOG_INFO, "", 0)
+	assert(err == nil, "can't create log: %s", err)
+
+	now := time.Now()
+	xl := ll.(*xLogger)
+	xl.SetClock(func() time.Time { return now })
+	xl.SetSampler(3, 10)
+
+	for i := 0; i < 100; i++ {
+		ll.Info("spam %d", i)
+	}
+	ll.Warn("other")
+
+	// the counters are reset in the next interval
+	now = now.Add(time.Second)
+	for i := 0; i < 5; i++ {
+		ll.Info("spam %d", i)
+	}
+	ll.Close()
+
+	out := wr.String()
+	n := strings.Count(out, "spam ")
+	assert(n == 3+9+3, "exp 15 lines, saw %d:\n%s", n, out)
+	for _, s := range []string{"spam 0\n", "spam 2\n", "spam 12\n", "spam 92\n", "other\n"} {
+		assert(strings.Contains(out, s), "missing %q:\n%s", s, out)
+	}
+	assert(!strings.Contains(out, "spam 3\n"), "unsampled line:\n%s", out)
+}
+
+func TestDedup(t *testing.T) {
+	assert := newAsserter(t, "dedup")
+	var wr bytes.Buffer
+
+	ll, err := New(&wr, LOG_INFO, "", 0)
+	assert(err == nil, "can't create log: %s", err)
+	ll.(*xLogger).SetDedup(time.Minute)
+
+	for i := 0; i < 5; i++ {
+		ll.Info("retrying connection")
+	}
+	ll.Info("connected")
+	ll.Close()
+
+	out := wr.String()
+	n := strings.Count(out, "retrying connection")
+	assert(n == 1, "exp 1 line, saw %d:\n%s", n, out)
+
+	n = strings.Count(out, "last message repeated")
+	assert(n == 1, "exp 1 summary, saw %d:\n%s", n, out)
+	assert(strings.Contains(out, "last message repeated 4 times\n"), "wrong summary:\n%s", out)
+
+	i := strings.Index(out, "repeated 4 times")
+	j := strings.Index(out, "connected\n")
+	assert(i < j, "summary must precede the next line:\n%s", out)
+}
+
+func TestDedupFormatter(t *testing.T) {
+	assert := newAsserter(t, "dedup-formatter")
+	var wr bytes.Buffer
+
+	ll, err := NewWithOptions(&wr, LOG_INFO, "", Lseqno, &Options{Quiet: true})
+	assert(err == nil, "can't create log: %s", err)
+
+	x := ll.(*xLogger)
+	x.SetFormatter(LogfmtFormatter{})
+	x.SetDedup(time.Minute)
+
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		x.SetClock(func() time.Time { return now.Add(time.Duration(i) * time.Second) })
+		ll.Info("retrying connection")
+	}
+	ll.Error("retrying connection")
+	ll.Close()
+
+	out := wr.String()
+	n := strings.Count(out, "level=info msg=\"retrying connection\"")
+	assert(n == 1, "exp 1 info line, saw %d:\n%s", n, out)
+	assert(strings.Contains(out, "last message repeated 4 times"), "wrong summary:\n%s", out)
+
+	i := strings.Index(out, "repeated 4 times")
+	j := strings.Index(out, "level=error msg=\"retrying connection\"")
+	assert(j > 0, "error line collapsed into the info lines:\n%s", out)
+	assert(i < j, "summary must precede the error line:\n%s", out)
+}
+
+func TestColor(t *testing.T) {
+	assert := newAsserter(t, "color")
+	var wr bytes.Buffer
+
+	ll, err := New(&wr, LOG_INFO, "", Lforcecolor)
+	assert(err == nil, "can't create log: %s", err)
+
+	ll.Error("red alert")
+	ll.Warn("yellow alert")
+	ll.Close()
+
+	out := wr.String()
+	assert(strings.Contains(out, "\x1b[31m<5>:\x1b[0m"), "missing red:\n%q", out)
+	assert(strings.Contains(out, "\x1b[33m<4>:\x1b[0m"), "missing yellow:\n%q", out)
+
+	// auto-detect: a buffer isn't a terminal
+	wr.Reset()
+	ll, err = New(&wr, LOG_INFO, "", Lcolor)
+	assert(err == nil, "can't create log: %s", err)
+
+	ll.Error("plain")
+	ll.Close()
+	assert(!strings.Contains(wr.String(), "\x1b["), "unexpected color:\n%q", wr.String())
+
+	// never in files
+	fn := filepath.Join(t.TempDir(), "color.log")
+	fl, err := NewFilelog(fn, LOG_INFO, "", Lforcecolor)
+	assert(err == nil, "can't create log: %s", err)
+
+	fl.Error("plain")
+	fl.Close()
+
+	b, err := os.ReadFile(fn)
+	assert(err == nil, "read: %s", err)
+	assert(!bytes.Contains(b, []byte("\x1b[")), "unexpected color in file:\n%q", b)
+}
+
+func TestNoColor(t *testing.T) {
+	assert := newAsserter(t, "nocolor")
+
+	// pretend every writer is a terminal
+	isterm := isTerminal
+	isTerminal = func(io.Writer) bool { return true }
+	defer func() { isTerminal = isterm }()
+
+	var wr bytes.Buffer
+	ll, err := New(&wr, LOG_INFO, "", Lcolor)
+	assert(err == nil, "can't create log: %s", err)
+	ll.Error("red")
+	ll.Close()
+	assert(strings.Contains(wr.String(), "\x1b[31m"), "missing color on tty:\n%q", wr.String())
+
+	t.Setenv("NO_COLOR", "1")
+
+	wr.Reset()
+	ll, err = New(&wr, LOG_INFO, "", Lcolor)
+	assert(err == nil, "can't create log: %s", err)
+	ll.Error("plain")
+	ll.Close()
+	assert(!strings.Contains(wr.String(), "\x1b["), "color despite NO_COLOR:\n%q", wr.String())
+
+	// an explicit force wins
+	wr.Reset()
+	ll, err = New(&wr, LOG_INFO, "", Lforcecolor)
+	assert(err == nil, "can't create log: %s", err)
+	ll.Error("red")
+	ll.Close()
+	assert(strings.Contains(wr.String(), "\x1b[31m"), "Lforcecolor ignored:\n%q", wr.String())
+}
+
+func logFromContext(ctx context.Context, msg string) {
+	FromContext(ctx).Info(msg)
+}
+
+func TestContext(t *testing.T) {
+	assert := newAsserter(t, "context")
+	var wr bytes.Buffer
+
+	ll, err := New(&wr, LOG_INFO, "", 0)
+	assert(err == nil, "can't create log: %s", err)
+
+	ctx := NewContext(context.Background(), ll.New("req-42", 0))
+	logFromContext(ctx, "handling request")
+	ll.Close()
+
+	out := wr.String()
+	assert(strings.Contains(out, "[req-42] handling request\n"), "missing prefix:\n%s", out)
+
+	nl := FromContext(context.Background())
+	assert(nl != nil, "nil logger from empty context")
+	nl.Info("discarded")
+}
+
+func TestSeqno(t *testing.T) {
+	assert := newAsserter(t, "seqno")
+	var wr bytes.Buffer
+
+	ll, err := New(&wr, LOG_INFO, "", Lseqno)
+	assert(err == nil, "can't create log: %s", err)
+
+	for i := 0; i < 10; i++ {
+		ll.Info("line %d", i)
+	}
+	ll.Close()
+
+	rx := re.MustCompile(`(?m)^([0-9]+) <`)
+	mx := rx.FindAllStringSubmatch(wr.String(), -1)
+	assert(len(mx) == 12, "exp 12 numbered lines, saw %d:\n%s", len(mx), wr.String())
+
+	prev := 0
+	for _, m := range mx {
+		var n int
+		fmt.Sscanf(m[1], "%d", &n)
+		assert(n > prev, "seqno not increasing: %d after %d", n, prev)
+		prev = n
+	}
+}
+
+func TestCounts(t *testing.T) {
+	assert := newAsserter(t, "counts")
+
+	ll, err := New(&nullWriter{}, LOG_INFO, "", 0)
+	assert(err == nil, "can't create log: %s", err)
+	defer ll.Close()
+
+	sub := ll.New("sub", LOG_DEBUG)
+	for i := 0; i < 3; i++ {
+		ll.Error("error %d", i)
+	}
+	ll.Info("info")
+	ll.Debug("not logged")
+	sub.Debug("debug")
+	sub.Warn("warn")
+
+	c := ll.(*xLogger).Counts()
+	exp := map[Priority]uint64{
+		LOG_DEBUG: 1,
+		LOG_INFO:  1,
+		LOG_WARN:  1,
+		LOG_ERR:   3,
+		LOG_CRIT:  0,
+		LOG_EMERG: 0,
+	}
+	for p, n := range exp {
+		assert(c[p] == n, "%s: exp %d, saw %d", p, n, c[p])
+	}
+}
+
+func TestCloseSummary(t *testing.T) {
+	assert := newAsserter(t, "closesummary")
+
+	var b bytes.Buffer
+	ll, err := New(&b, LOG_INFO, "", Llevelname)
+	assert(err == nil, "can't create log: %s", err)
+
+	start := time.Date(2024, 1, 23, 10, 0, 0, 0, time.UTC)
+	now := start
+	x := ll.(*xLogger)
+	x.SetClock(func() time.Time { return now })
+	x.SetCloseSummary(true)
+	x.SetDeferredFormat(true)
+
+	for i := 0; i < 3; i++ {
+		ll.Error("error %d", i)
+	}
+	ll.Info("info")
+	ll.New("sub", 0).Warn("warn")
+
+	now = start.Add(90 * time.Minute)
+	ll.Close()
+
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	assert(len(lines) >= 2, "too few lines:\n%s", b.String())
+
+	// the summary precedes the close line
+	s := lines[len(lines)-2]
+	exp := "INFO: Logger summary: DEBUG=0 INFO=1 NOTICE=0 WARNING=1 ERROR=3 CRITICAL=0 ALERT=0 EMERGENCY=0 dropped=0 uptime=1h30m0s"
+	assert(s == exp, "\nexp %q\nsaw %q", exp, s)
+	assert(strings.Contains(lines[len(lines)-1], "closed."), "summary is not before the close line:\n%s", b.String())
+
+	// off by default
+	b.Reset()
+	ll, err = New(&b, LOG_INFO, "", 0)
+	assert(err == nil, "can't create log: %s", err)
+	ll.Error("oops")
+	ll.Close()
+	assert(!strings.Contains(b.String(), "summary"), "unexpected summary:\n%s", b.String())
+}
+
+func TestFramer(t *testing.T) {
+	assert := newAsserter(t, "framer")
+
+	for _, batch := range []bool{false, true} {
+		var b bytes.Buffer
+		ll, err := NewWithOptions(&b, LOG_INFO, "", Llevelname, &Options{Quiet: true, Batch: batch})
+		assert(err == nil, "can't create log: %s", err)
+
+		// 4-byte big-endian length prefix
+		ll.(*xLogger).SetFramer(func(buf []byte) []byte {
+			var h [4]byte
+			binary.BigEndian.PutUint32(h[:], uint32(len(buf)))
+			return append(h[:], buf...)
+		})
+
+		ll.Info("hello")
+		ll.Warn("world!")
+		assert(ll.Flush() == nil, "batch %v: flush failed", batch)
+
+		// clones frame their records too
+		cl := ll.Clone()
+		cl.Info("clone")
+		cl.Close()
+		ll.Close()
+
+		out := b.Bytes()
+		assert(bytes.HasPrefix(out, []byte{0, 0, 0, 12, 'I', 'N', 'F', 'O'}), "batch %v: bad prefix %q", batch, out)
+
+		var recs []string
+		for len(out) >= 4 {
+			n := int(binary.BigEndian.Uint32(out))
+			assert(len(out) >= 4+n, "batch %v: short record %q", batch, out)
+			recs = append(recs, string(out[4:4+n]))
+			out = out[4+n:]
+		}
+		assert(len(out) == 0, "batch %v: trailing garbage %q", batch, out)
+
+		exp := []string{"INFO: hello\n", "WARNING: world!\n", "INFO: clone\n"}
+		assert(len(recs) == len(exp), "batch %v: exp %d records, saw %q", batch, len(exp), recs)
+		for i := range exp {
+			assert(recs[i] == exp[i], "batch %v: exp %q, saw %q", batch, exp[i], recs[i])
+		}
+	}
+}
+
+// writer that blocks - once armed - until released
+type blockWriter struct {
+	armed   atomic.Bool
+	release chan struct{}
+}
+
+func (w *blockWriter) Write(b []byte) (int, error) {
+	if w.armed.Load() {
+		<-w.release
+	}
+	return len(b), nil
+}
+
+func TestCloseTimeout(t *testing.T) {
+	assert := newAsserter(t, "closetimeout")
+
+	bw := &blockWriter{release: make(chan struct{})}
+	defer close(bw.release)
+
+	ll, err := New(bw, LOG_DEBUG, "foo", 0)
+	assert(err == nil, "can't create log: %s", err)
+
+	bw.armed.Store(true)
+	ll.Info("hello")
+
+	done := make(chan error, 1)
+	go func() {
+		done <- ll.CloseTimeout(50 * time.Millisecond)
+	}()
+
+	select {
+	case err = <-done:
+		assert(err != nil, "expected timeout error")
+		assert(strings.Contains(err.Error(), "timed out"), "unexpected error: %s", err)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("CloseTimeout hung")
+	}
+
+	// the logger must not accept more writes
+	x := ll.(*xLogger)
+	assert(x.ch.closed.Load(), "logger not marked closed")
+	ll.Info("dropped")
+	assert(ll.Close() == nil, "second close failed")
+}
+
+// blockWriter that signals when a write blocks
+type enterWriter struct {
+	blockWriter
+	entered chan struct{}
+	once    sync.Once
+}
+
+func (w *enterWriter) Write(b []byte) (int, error) {
+	if w.armed.Load() {
+		w.once.Do(func() { close(w.entered) })
+	}
+	return w.blockWriter.Write(b)
+}
+
+func TestCloseTimeoutSync(t *testing.T) {
+	assert := newAsserter(t, "closetimeout-sync")
+
+	ew := &enterWriter{entered: make(chan struct{})}
+	ew.release = make(chan struct{})
+	defer close(ew.release)
+
+	ll, err := NewWithOptions(ew, LOG_DEBUG, "foo", 0, &Options{Synchronous: true, Quiet: true})
+	assert(err == nil, "can't create log: %s", err)
+
+	// the write blocks in the caller
+	ew.armed.Store(true)
+	go ll.Info("hello")
+	<-ew.entered
+
+	done := make(chan error, 1)
+	go func() {
+		done <- ll.CloseTimeout(50 * time.Millisecond)
+	}()
+
+	select {
+	case err = <-done:
+		assert(err != nil, "expected timeout error")
+		assert(strings.Contains(err.Error(), "timed out"), "unexpected error: %s", err)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("CloseTimeout hung")
+	}
+}
+
+// writer that panics on the first write after being armed
+type panicWriter struct {
+	bytes.Buffer
+	armed atomic.Bool
+}
+
+func (w *panicWriter) Write(b []byte) (int, error) {
+	if w.armed.Swap(false) {
+		panic("bad sink")
+	}
+	return w.Buffer.Write(b)
+}
+
+func TestWriterPanic(t *testing.T) {
+	assert := newAsserter(t, "writerpanic")
+
+	var perr atomic.Value
+	pw := &panicWriter{}
+	ll, err := New(pw, LOG_DEBUG, "foo", 0)
+	assert(err == nil, "can't create log: %s", err)
+
+	ll.(*xLogger).SetErrorHandler(func(err error) { perr.Store(err) })
+	pw.armed.Store(true)
+	ll.Info("boom")
+	ll.Info("after")
+	ll.Close()
+
+	out := pw.String()
+	assert(!strings.Contains(out, "boom"), "panicked write logged:\n%s", out)
+	assert(strings.Contains(out, "after"), "subsequent write lost:\n%s", out)
+	assert(perr.Load() != nil, "panic not reported")
+}
+
+func TestQueueDepth(t *testing.T) {
+	assert := newAsserter(t, "queuedepth")
+
+	var b bytes.Buffer
+	ll, err := NewWithOptions(&b, LOG_DEBUG, "foo", 0, &Options{QueueDepth: 4096})
+	assert(err == nil, "can't create log: %s", err)
+
+	x := ll.(*xLogger)
+	assert(cap(x.ch.logch) == 4096, "queue depth: exp 4096, saw %d", cap(x.ch.logch))
+
+	const N = 10000
+	for i := 0; i < N; i++ {
+		ll.Info("line %d", i)
+	}
+	ll.Close()
+
+	// banner + N lines + close message
+	n := strings.Count(b.String(), "\n")
+	assert(n == N+2, "exp %d lines, saw %d", N+2, n)
+
+	ll, err = NewWithOptions(&b, LOG_DEBUG, "foo", 0, nil)
+	assert(err == nil, "can't create log: %s", err)
+	x = ll.(*xLogger)
+	assert(cap(x.ch.logch) == (*Options)(nil).queueDepth(), "default queue depth %d", cap(x.ch.logch))
+	ll.Close()
+}
+
+// writer that takes a while for each write
+type slowWriter struct{}
+
+func (w *slowWriter) Write(b []byte) (int, error) {
+	time.Sleep(10 * time.Microsecond)
+	return len(b), nil
+}
+
+// benchmark how often producers find the queue full (and thus block)
+// during bursts to a slow writer.
+func benchmarkQueueDepth(b *testing.B, depth int) {
+	ll, err := NewWithOptions(&slowWriter{}, LOG_DEBUG, "bench", 0, &Options{QueueDepth: depth})
+	if err != nil {
+		b.Fatalf("can't create log: %s", err)
+	}
+
+	x := ll.(*xLogger)
+	var full int
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// bursts of 1000 lines
+		if i%1000 == 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if len(x.ch.logch) == cap(x.ch.logch) {
+			full++
+		}
+		ll.Info("burst line %d", i)
+	}
+	b.StopTimer()
+	ll.Close()
+	b.ReportMetric(float64(full)/float64(b.N), "blocked/op")
+}
+
+func BenchmarkQueueDepth8(b *testing.B)    { benchmarkQueueDepth(b, 8) }
+func BenchmarkQueueDepth4096(b *testing.B) { benchmarkQueueDepth(b, 4096) }
+
+func TestBufSize(t *testing.T) {
+	assert := newAsserter(t, "bufsize")
+
+	ll, err := NewWithOptions(&nullWriter{}, LOG_DEBUG, "", 0, &Options{BufSize: 4096})
+	assert(err == nil, "can't create log: %s", err)
+	defer ll.Close()
+
+	x := ll.(*xLogger)
+	b := x.getBuf()
+	assert(cap(b) >= 4096, "buffer too small: %d", cap(b))
+
+	// oversized buffers aren't pooled
+	x.putBuf(make([]byte, 0, 4096*_LOGBUFGROW+1))
+	b = x.getBuf()
+	assert(cap(b) <= 4096*_LOGBUFGROW, "oversized buffer pooled: %d", cap(b))
+}
+
+// benchmark logging 2KB lines with a buffer size of 'sz'
+func benchmarkBufSize(b *testing.B, sz int) {
+	ll, err := NewWithOptions(&nullWriter{}, LOG_DEBUG, "bench", 0, &Options{BufSize: sz})
+	if err != nil {
+		b.Fatalf("can't create log: %s", err)
+	}
+
+	msg := strings.Repeat("x", 2048)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ll.Info("%s", msg)
+	}
+	b.StopTimer()
+	ll.Close()
+}
+
+func BenchmarkBufSizeDefault(b *testing.B) { benchmarkBufSize(b, 0) }
+func BenchmarkBufSize4K(b *testing.B)      { benchmarkBufSize(b, 4096) }
+
+// counts the writes; blocks writes until released if armed
+type countingWriter struct {
+	sync.Mutex
+	blockWriter
+	writes int
+	buf    bytes.Buffer
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	w.blockWriter.Write(b)
+
+	w.Lock()
+	defer w.Unlock()
+	w.writes++
+	return w.buf.Write(b)
+}
+
+func TestBatch(t *testing.T) {
+	assert := newAsserter(t, "batch")
+
+	cw := &countingWriter{}
+	cw.release = make(chan struct{})
+
+	ll, err := NewWithOptions(cw, LOG_DEBUG, "", Llevelname, &Options{QueueDepth: 256, Batch: true})
+	assert(err == nil, "can't create log: %s", err)
+
+	// qrunner blocks on the first line while the rest queue up
+	cw.armed.Store(true)
+	for i := 0; i < 100; i++ {
+		ll.Info("line %d", i)
+	}
+	cw.armed.Store(false)
+	close(cw.release)
+	ll.Close()
+
+	assert(cw.writes < 10, "exp batched writes, saw %d", cw.writes)
+
+	lines := strings.Split(cw.buf.String(), "\n")
+	assert(len(lines) == 103, "exp 102 lines, saw %d:\n%s", len(lines)-1, cw.buf.String())
+	for i, s := range lines[1:101] {
+		exp := fmt.Sprintf("INFO: line %d", i)
+		assert(s == exp, "line %d: exp %q, saw %q", i, exp, s)
+	}
+}
+
+// benchmark the number of writes per line at high message rates
+func benchmarkBatch(b *testing.B, batch bool) {
+	cw := &countingWriter{}
+	ll, err := NewWithOptions(cw, LOG_DEBUG, "bench", 0, &Options{QueueDepth: 1024, Batch: batch})
+	if err != nil {
+		b.Fatalf("can't create log: %s", err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ll.Info("busy line %d", i)
+	}
+	ll.Close()
+	b.StopTimer()
+	b.ReportMetric(float64(cw.writes)/float64(b.N), "writes/op")
+}
+
+func BenchmarkNoBatch(b *testing.B) { benchmarkBatch(b, false) }
+func BenchmarkBatch(b *testing.B)   { benchmarkBatch(b, true) }
+
+func TestMaxLineLen(t *testing.T) {
+	assert := newAsserter(t, "maxlinelen")
+
+	var b bytes.Buffer
+	ll, err := New(&b, LOG_DEBUG, "foo", Ldate|Ltime)
+	assert(err == nil, "can't create log: %s", err)
+
+	ll.(*xLogger).SetMaxLineLen(100)
+	ll.Info("%s", strings.Repeat("x", 10240))
+	ll.Info("short")
+	ll.Close()
+
+	lines := strings.Split(b.String(), "\n")
+	assert(len(lines) > 2, "too few lines:\n%s", b.String())
+
+	long := lines[1]
+	exp := strings.Repeat("x", 100) + "...[truncated 10140 bytes]"
+	assert(strings.HasSuffix(long, exp), "missing truncation marker: %q", long)
+	assert(!strings.Contains(long, strings.Repeat("x", 101)), "not truncated: %q", long)
+	assert(strings.HasSuffix(lines[2], "[foo] short"), "short line mangled: %q", lines[2])
+
+	// must not split a multi-byte rune
+	x := truncate([]byte("héllo"), 0, 2)
+	assert(string(x) == "h...[truncated 5 bytes]", "rune split: %q", x)
+}
+
+func TestRedact(t *testing.T) {
+	assert := newAsserter(t, "redact")
+
+	var b bytes.Buffer
+	ll, err := New(&b, LOG_DEBUG, "foo", 0)
+	assert(err == nil, "can't create log: %s", err)
+
+	x := ll.(*xLogger)
+	x.AddRedactor(re.MustCompile(`\b\d{16}\b`), "****")
+	x.AddRedactor(re.MustCompile(`token=\S+`), "token=<redacted>")
+
+	ll.Info("card 4111111111111111 charged")
+	ll.New("sub", LOG_DEBUG).Info("auth token=s3cr3t ok")
+	ll.WithFields(map[string]any{"pan": "4111111111111111"}).Info("fields")
+	ll.Close()
+
+	out := b.String()
+	assert(!strings.Contains(out, "4111111111111111"), "card number leaked:\n%s", out)
+	assert(!strings.Contains(out, "s3cr3t"), "token leaked:\n%s", out)
+	assert(strings.Contains(out, "card **** charged"), "card not redacted:\n%s", out)
+	assert(strings.Contains(out, "auth token=<redacted> ok"), "token not redacted:\n%s", out)
+	assert(strings.Contains(out, "pan=****"), "field not redacted:\n%s", out)
+}
+
+func TestNoticeAlert(t *testing.T) {
+	assert := newAsserter(t, "notice-alert")
+
+	var b bytes.Buffer
+	ll, err := New(&b, LOG_NOTICE, "", Llevelname)
+	assert(err == nil, "can't create log: %s", err)
+
+	assert(!ll.Loggable(LOG_INFO), "notice logger logs info")
+	assert(ll.Loggable(LOG_NOTICE), "notice logger doesn't log notice")
+	assert(ll.Loggable(LOG_WARN), "notice logger doesn't log warn")
+
+	ll.Info("hidden")
+	ll.Notice("heads up")
+	ll.Alert("wake up")
+
+	al := ll.New("al", LOG_ALERT)
+	assert(!al.Loggable(LOG_CRIT), "alert logger logs crit")
+	assert(al.Loggable(LOG_ALERT) && al.Loggable(LOG_EMERG), "alert logger drops alert")
+	ll.Close()
+
+	out := b.String()
+	assert(!strings.Contains(out, "hidden"), "info logged:\n%s", out)
+	assert(strings.Contains(out, "NOTICE: heads up\n"), "missing notice:\n%s", out)
+	assert(strings.Contains(out, "ALERT: wake up\n"), "missing alert:\n%s", out)
+
+	// 1:1 with syslog severities
+	for p := LOG_DEBUG; p < logMax; p++ {
+		assert(prioSeverity[p] == int(logMax-1-p), "%s: wrong severity %d", p, prioSeverity[p])
+	}
+
+	p, ok := ToPriority("notice")
+	assert(ok && p == LOG_NOTICE, "notice: %s", p)
+	p, ok = ToPriority("LOG_ALERT")
+	assert(ok && p == LOG_ALERT, "alert: %s", p)
+}
+
+func TestLevelEnabled(t *testing.T) {
+	assert := newAsserter(t, "level-enabled")
+
+	ll, err := New(&nullWriter{}, LOG_WARN, "", 0)
+	assert(err == nil, "can't create log: %s", err)
+	defer ll.Close()
+
+	tests := []struct {
+		p   Priority
+		exp bool
+	}{
+		{LOG_NONE, false},
+		{LOG_DEBUG, false},
+		{LOG_NOTICE, false},
+		{LOG_WARN, true},
+		{LOG_ERR, true},
+		{LOG_EMERG, true},
+		{logMax, false},
+		{logMax + 10, false},
+		{-1, false},
+	}
+
+	nl := NewNoneLogger(LOG_WARN, "")
+	for _, tc := range tests {
+		assert(ll.LevelEnabled(tc.p) == tc.exp, "%s: exp %v", tc.p, tc.exp)
+		assert(ll.Loggable(tc.p) == tc.exp, "loggable %s: exp %v", tc.p, tc.exp)
+		assert(nl.LevelEnabled(tc.p) == tc.exp, "null %s: exp %v", tc.p, tc.exp)
+	}
+
+	// a disabled logger logs nothing
+	off := NewNoneLogger(LOG_NONE, "")
+	for p := LOG_NONE; p <= logMax; p++ {
+		assert(!off.LevelEnabled(p), "disabled logger enables %s", p)
+	}
+}
+
+func TestLogPrio(t *testing.T) {
+	assert := newAsserter(t, "logprio")
+
+	var b bytes.Buffer
+	ll, err := New(&b, LOG_WARN, "foo", Lfileloc)
+	assert(err == nil, "can't create log: %s", err)
+
+	ll.Log(LOG_ERR, "upstream %d", 503)
+	ll.Log(LOG_DEBUG, "noisy %d", 200)
+	ll.Close()
+
+	out := b.String()
+	assert(re.MustCompile(`<5>: \[foo\] \(logger_test\.go:\d+\) upstream 503`).MatchString(out), "missing err line:\n%s", out)
+	assert(!strings.Contains(out, "noisy"), "debug line logged:\n%s", out)
+}
+
+func TestErrorf(t *testing.T) {
+	assert := newAsserter(t, "errorf")
+
+	var b bytes.Buffer
+	ll, err := New(&b, LOG_WARN, "foo", 0)
+	assert(err == nil, "can't create log: %s", err)
+
+	err = ll.Errorf("open %s: %w", "app.conf", os.ErrNotExist)
+	assert(err != nil, "nil error")
+	assert(err.Error() == "open app.conf: "+os.ErrNotExist.Error(), "wrong msg: %s", err)
+	assert(errors.Is(err, os.ErrNotExist), "error not wrapped")
+
+	cerr := ll.Critf("disk %d%% full", 99)
+	assert(cerr.Error() == "disk 99% full", "wrong msg: %s", cerr)
+	ll.Close()
+
+	out := b.String()
+	assert(re.MustCompile(`<5>:.* \[foo\] open app.conf: file does not exist\n`).MatchString(out), "missing err line:\n%s", out)
+	assert(re.MustCompile(`<6>:.* \[foo\] disk 99% full\n`).MatchString(out), "missing crit line:\n%s", out)
+
+	nl := NewNoneLogger(LOG_DEBUG, "")
+	err = nl.Errorf("x %d", 1)
+	assert(err != nil && err.Error() == "x 1", "null logger: %v", err)
+}
+
+// buffered writer that records Flush calls
+type flushWriter struct {
+	sync.Mutex
+	buf     bytes.Buffer
+	pending bytes.Buffer
+	flushes atomic.Int64
+}
+
+func (w *flushWriter) Write(b []byte) (int, error) {
+	w.Lock()
+	defer w.Unlock()
+	return w.pending.Write(b)
+}
+
+func (w *flushWriter) Flush() error {
+	w.Lock()
+	defer w.Unlock()
+	w.pending.WriteTo(&w.buf)
+	w.flushes.Add(1)
+	return nil
+}
+
+func (w *flushWriter) String() string {
+	w.Lock()
+	defer w.Unlock()
+	return w.buf.String()
+}
+
+func TestFlush(t *testing.T) {
+	assert := newAsserter(t, "flush")
+
+	fw := &flushWriter{}
+	ll, err := New(fw, LOG_DEBUG, "foo", 0)
+	assert(err == nil, "can't create log: %s", err)
+	defer ll.Close()
+
+	for i := 0; i < 100; i++ {
+		ll.Info("burst %d", i)
+	}
+
+	// the burst must be flushed without closing the logger
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(fw.String(), "burst 99\n") {
+		assert(time.Now().Before(deadline), "burst not flushed:\n%s", fw.String())
+		time.Sleep(time.Millisecond)
+	}
+
+	n := fw.flushes.Load()
+	assert(n > 0 && n <= 100, "unexpected flush count %d", n)
+}
+
+// a buffered writer that blocks when armed and records the lines
+// written out by each flush
+type snapWriter struct {
+	blockWriter
+	sync.Mutex
+	pending bytes.Buffer
+	snaps   []string
+}
+
+func (w *snapWriter) Write(b []byte) (int, error) {
+	w.blockWriter.Write(b)
+
+	w.Lock()
+	defer w.Unlock()
+	return w.pending.Write(b)
+}
+
+func (w *snapWriter) Flush() error {
+	w.Lock()
+	defer w.Unlock()
+	w.snaps = append(w.snaps, w.pending.String())
+	w.pending.Reset()
+	return nil
+}
+
+func TestFlushLevel(t *testing.T) {
+	assert := newAsserter(t, "flushlevel")
+
+	sw := &snapWriter{}
+	sw.release = make(chan struct{})
+
+	ll, err := NewWithOptions(sw, LOG_DEBUG, "", Llevelname, &Options{QueueDepth: 16})
+	assert(err == nil, "can't create log: %s", err)
+
+	x := ll.(*xLogger)
+	x.SetFlushLevel(LOG_ERR)
+	x.SetRingBuffer(64)
+	x.SetCloseSummary(true)
+	x.SetDeferredFormat(true)
+
+	// queue up lines behind a blocked write; without a forced flush
+	// the writer is flushed only once the queue is drained.
+	sw.armed.Store(true)
+	ll.Info("first")
+	deadline := time.Now().Add(5 * time.Second)
+	for x.QueueLen() > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	ll.Info("second")
+	ll.Error("boom")
+	ll.Info("after")
+
+	sw.armed.Store(false)
+	close(sw.release)
+	ll.Close()
+
+	var snap string
+	for _, s := range sw.snaps {
+		if strings.Contains(s, "first\n") {
+			snap = s
+		}
+	}
+	assert(strings.HasSuffix(snap, "second\nERROR: boom\n"), "info lines not buffered till the error: %q", sw.snaps)
+	assert(!strings.Contains(snap, "after"), "error not flushed right away: %q", sw.snaps)
+}
+
+func TestNewNilWriter(t *testing.T) {
+	assert := newAsserter(t, "new-nil")
+
+	ll, err := New(nil, LOG_INFO, "foo", 0)
+	assert(err != nil, "expected error for nil writer")
+	assert(ll == nil, "expected nil logger")
+
+	_, err = NewWithOptions(nil, LOG_INFO, "foo", 0, &Options{QueueDepth: 8})
+	assert(err != nil, "expected error for nil writer")
+
+	_, err = NewRFC5424(nil, LOG_INFO, "foo")
+	assert(err != nil, "expected error for nil writer")
+}
+
+func TestNoneRotatable(t *testing.T) {
+	assert := newAsserter(t, "none-rotatable")
+
+	ll, err := NewLogger("NONE", LOG_INFO, "foo", 0)
+	assert(err == nil, "can't create log: %s", err)
+
+	rl, ok := ll.(RotatableLogger)
+	assert(ok, "NONE logger is not a RotatableLogger")
+	assert(rl.EnableRotation(0, 0, 0, 3) == nil, "enable rotation failed")
+	assert(rl.ConfigureRotation(&RotateConfig{Dated: true}) == nil, "configure rotation failed")
+	assert(rl.Rotate() == nil, "rotate failed")
+	assert(rl.Close() == nil, "close failed")
+}
+
+func TestWriter(t *testing.T) {
+	assert := newAsserter(t, "writer")
+
+	var b bytes.Buffer
+	ll, err := New(&b, LOG_INFO, "foo", 0)
+	assert(err == nil, "can't create log: %s", err)
+
+	ll.Info("before")
+	w := ll.Writer()
+
+	// the writer must not retain the caller's buffer
+	raw := []byte("+------+\n| raw  |\n+------+\n")
+	n, err := w.Write(raw)
+	assert(err == nil && n == len(raw), "write: %d, %v", n, err)
+	copy(raw, "XXXXXXXX")
+	ll.Info("after")
+	ll.Close()
+
+	out := b.String()
+	i := strings.Index(out, "before\n")
+	j := strings.Index(out, "+------+\n| raw  |\n+------+\n")
+	k := strings.Index(out, "after\n")
+	assert(i >= 0 && j > i && k > j, "raw bytes missing or out of order:\n%s", out)
+	assert(!strings.Contains(out, "XXXX"), "writer retained caller buffer:\n%s", out)
+
+	nl := NewNoneLogger(LOG_INFO, "")
+	assert(nl.Writer() == io.Discard, "null logger writer is not io.Discard")
+}
+
+func TestHostPid(t *testing.T) {
+	assert := newAsserter(t, "hostpid")
+
+	host, err := os.Hostname()
+	assert(err == nil, "hostname: %s", err)
+
+	var b bytes.Buffer
+	ll, err := New(&b, LOG_INFO, "foo", Lhostname|Lpid)
+	assert(err == nil, "can't create log: %s", err)
+	ll.Info("hello")
+	ll.Close()
+
+	exp := fmt.Sprintf("[foo] %s[%d] hello\n", host, os.Getpid())
+	assert(strings.Contains(b.String(), exp), "exp %q in:\n%s", exp, b.String())
+
+	b.Reset()
+	ll, err = New(&b, LOG_INFO, "", Lpid)
+	assert(err == nil, "can't create log: %s", err)
+	ll.Info("hello")
+	ll.Close()
+
+	exp = fmt.Sprintf(">: [%d] hello\n", os.Getpid())
+	assert(strings.Contains(b.String(), exp), "exp %q in:\n%s", exp, b.String())
+}
+
+// bytes.Buffer that is safe for concurrent writers
+type lockedBuffer struct {
+	sync.Mutex
+	b bytes.Buffer
+}
+
+func (w *lockedBuffer) Write(b []byte) (int, error) {
+	w.Lock()
+	defer w.Unlock()
+	return w.b.Write(b)
+}
+
+func (w *lockedBuffer) String() string {
+	w.Lock()
+	defer w.Unlock()
+	return w.b.String()
+}
+
+func TestClone(t *testing.T) {
+	assert := newAsserter(t, "clone")
+
+	var b lockedBuffer
+	ll, err := New(&b, LOG_INFO, "orig", 0)
+	assert(err == nil, "can't create log: %s", err)
+
+	cl := ll.Clone()
+	assert(cl.Prefix() == ll.Prefix(), "prefix: exp %q, saw %q", ll.Prefix(), cl.Prefix())
+	assert(cl.Prio() == ll.Prio(), "prio: exp %s, saw %s", ll.Prio(), cl.Prio())
+
+	cl.Info("from clone")
+	err = cl.Close()
+	assert(err == nil, "clone close: %s", err)
+
+	cl.Info("after clone close")
+	ll.Info("original still logs")
+	ll.Close()
+
+	out := b.String()
+	assert(strings.Contains(out, "[orig] from clone\n"), "missing clone line:\n%s", out)
+	assert(strings.Contains(out, "[orig] original still logs\n"), "missing original line:\n%s", out)
+	assert(!strings.Contains(out, "after clone close"), "closed clone logged:\n%s", out)
+
+	// closing the clone of a file logger must not close the file
+	fn := filepath.Join(t.TempDir(), "app.log")
+	fl, err := NewFilelog(fn, LOG_INFO, "", 0)
+	assert(err == nil, "can't create log: %s", err)
+
+	fl.Clone().Close()
+	fl.Info("file still open")
+	fl.Close()
+
+	cur, err := os.ReadFile(fn)
+	assert(err == nil, "read log: %s", err)
+	assert(strings.Contains(string(cur), "file still open\n"), "missing line:\n%s", cur)
+}
+
+func TestCloneSettings(t *testing.T) {
+	assert := newAsserter(t, "clone-settings")
+
+	ll, err := NewWithOptions(io.Discard, LOG_INFO, "", 0, &Options{Quiet: true})
+	assert(err == nil, "can't create log: %s", err)
+	defer ll.Close()
+
+	x := ll.(*xLogger)
+	x.SetOverflowPolicy(DropOldest)
+	x.SetFlushLevel(LOG_ERR)
+	x.SetRingBuffer(64)
+	x.SetCloseSummary(true)
+	x.SetDeferredFormat(true)
+
+	cl := ll.Clone().(*xLogger)
+	defer cl.Close()
+
+	p := OverflowPolicy(cl.ch.overflow.Load())
+	assert(p == DropOldest, "overflow policy: exp %d, saw %d", DropOldest, p)
+
+	fl := Priority(cl.ch.flvl.Load())
+	assert(fl == LOG_ERR, "flush level: exp %s, saw %s", LOG_ERR, fl)
+
+	r := cl.ch.ring.Load()
+	assert(r != nil && r != x.ch.ring.Load(), "clone shares or lacks the ring buffer")
+	assert(r.max == 64, "ring buffer: exp capacity 64, saw %d", r.max)
+	assert(cl.ch.summary.Load(), "close summary not copied")
+	assert(cl.ch.deferfmt.Load(), "deferred formatting not copied")
+}
+
+func TestIsClosed(t *testing.T) {
+	assert := newAsserter(t, "isclosed")
+
+	var b bytes.Buffer
+	ll, err := New(&b, LOG_INFO, "foo", 0)
+	assert(err == nil, "can't create log: %s", err)
+
+	sub := ll.New("sub", LOG_DEBUG)
+	assert(!ll.IsClosed() && !sub.IsClosed(), "open logger reports closed")
+
+	ll.Close()
+	assert(ll.IsClosed(), "closed logger reports open")
+	assert(sub.IsClosed(), "sub-logger of closed logger reports open")
+
+	// must neither panic nor block
+	done := make(chan struct{})
+	go func() {
+		ll.Info("dropped")
+		sub.Error("dropped")
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("logging after close blocked")
+	}
+
+	assert(!strings.Contains(b.String(), "dropped"), "logged after close:\n%s", b.String())
+	c := ll.(*xLogger).Counts()
+	assert(c[LOG_INFO] == 0 && c[LOG_ERR] == 0, "post-close lines counted: %v", c)
+}
+
+// render only the message
+type msgFormatter struct{}
+
+func (msgFormatter) Format(b []byte, e Event) []byte {
+	b = append(b, e.Msg...)
+	return append(b, '\n')
+}
+
+func TestFormatter(t *testing.T) {
+	assert := newAsserter(t, "formatter")
+
+	var b bytes.Buffer
+	ll, err := New(&b, LOG_DEBUG, "foo", Ldate|Ltime|Lfileloc)
+	assert(err == nil, "can't create log: %s", err)
+
+	x := ll.(*xLogger)
+	x.SetFormatter(msgFormatter{})
+	ll.Info("hello %d", 1)
+
+	sub := ll.New("sub", LOG_DEBUG)
+	sub.Warn("world\n")
+
+	x.SetFormatter(nil)
+	ll.Info("text")
+	ll.Close()
+
+	lines := strings.Split(b.String(), "\n")
+	assert(len(lines) > 3, "too few lines:\n%s", b.String())
+	assert(lines[1] == "hello 1", "wrong formatted line: %q", lines[1])
+	assert(lines[2] == "world", "wrong sub-logger line: %q", lines[2])
+
+	exp := re.MustCompile(`^<2>:[0-9/]+ [0-9:.]+ \[foo\] \(logger_test.go:\d+\) text$`)
+	assert(exp.MatchString(lines[3]), "text layout not restored: %q", lines[3])
+
+	// the default text formatter renders the same layout
+	e := Event{Prio: LOG_WARN, Flags: Lfileloc, Prefix: "[a] ", File: "x.go", Line: 7, Msg: []byte("m")}
+	y := TextFormatter{}.Format(nil, e)
+	assert(string(y) == "<4>: [a] (x.go:7) m\n", "wrong text layout: %q", y)
+}
+
+func TestDropOldest(t *testing.T) {
+	assert := newAsserter(t, "dropoldest")
+
+	cw := &countingWriter{}
+	cw.release = make(chan struct{})
+
+	ll, err := NewWithOptions(cw, LOG_DEBUG, "", Llevelname, &Options{QueueDepth: 4})
+	assert(err == nil, "can't create log: %s", err)
+
+	x := ll.(*xLogger)
+	x.SetOverflowPolicy(DropOldest)
+
+	cw.armed.Store(true)
+	for i := 0; i < 100; i++ {
+		ll.Info("msg %d", i)
+	}
+
+	cw.armed.Store(false)
+	close(cw.release)
+	ll.Close()
+
+	out := cw.buf.String()
+	for i := 96; i < 100; i++ {
+		s := fmt.Sprintf("msg %d\n", i)
+		assert(strings.Contains(out, s), "missing %q:\n%s", s, out)
+	}
+	assert(!strings.Contains(out, "msg 50\n"), "old line survived:\n%s", out)
+	assert(x.Dropped() >= 90, "dropped %d lines", x.Dropped())
+}
+
+func TestDropOldestFlush(t *testing.T) {
+	assert := newAsserter(t, "dropoldest-flush")
+
+	cw := &countingWriter{}
+	cw.release = make(chan struct{})
+
+	ll, err := NewWithOptions(cw, LOG_DEBUG, "", Llevelname, &Options{QueueDepth: 4, Quiet: true})
+	assert(err == nil, "can't create log: %s", err)
+
+	x := ll.(*xLogger)
+	x.SetOverflowPolicy(DropOldest)
+
+	cw.armed.Store(true)
+	for i := 0; i < 5; i++ {
+		ll.Info("msg %d", i)
+	}
+
+	flushed := make(chan error, 1)
+	go func() {
+		flushed <- ll.Flush()
+	}()
+
+	// the pending flush must neither be evicted nor block the callers
+	logged := make(chan struct{})
+	go func() {
+		for i := 5; i < 100; i++ {
+			ll.Info("msg %d", i)
+		}
+		close(logged)
+	}()
+
+	select {
+	case <-logged:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("callers blocked behind a pending flush")
+	}
+
+	cw.armed.Store(false)
+	close(cw.release)
+
+	select {
+	case err = <-flushed:
+		assert(err == nil, "flush: %s", err)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("flush didn't return")
+	}
+
+	// the lines queued before the flush ran are written
+	ll.Close()
+	out := cw.buf.String()
+	for i := 96; i < 100; i++ {
+		s := fmt.Sprintf("msg %d\n", i)
+		assert(strings.Contains(out, s), "missing %q:\n%s", s, out)
+	}
+}
+
+func TestQueueLen(t *testing.T) {
+	assert := newAsserter(t, "queuelen")
+
+	bw := &blockWriter{release: make(chan struct{})}
+	ll, err := NewWithOptions(bw, LOG_DEBUG, "", Llevelname, &Options{QueueDepth: 16})
+	assert(err == nil, "can't create log: %s", err)
+
+	x := ll.(*xLogger)
+	assert(x.QueueCap() == 16, "exp cap 16, saw %d", x.QueueCap())
+
+	// the I/O goroutine blocks on the first line; the rest wait in
+	// the queue
+	bw.armed.Store(true)
+	for i := 0; i < 10; i++ {
+		ll.Info("msg %d", i)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for x.QueueLen() != 9 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	assert(x.QueueLen() == 9, "exp 9 queued, saw %d", x.QueueLen())
+
+	bw.armed.Store(false)
+	close(bw.release)
+	ll.Close()
+	assert(x.QueueLen() == 0, "queue not drained: %d", x.QueueLen())
+}
+
+func TestRingBuffer(t *testing.T) {
+	assert := newAsserter(t, "ringbuffer")
+
+	cw := &countingWriter{}
+	cw.release = make(chan struct{})
+
+	ll, err := NewWithOptions(cw, LOG_DEBUG, "", Llevelname, &Options{QueueDepth: 1, Quiet: true})
+	assert(err == nil, "can't create log: %s", err)
+
+	x := ll.(*xLogger)
+	x.SetRingBuffer(8)
+
+	// the writer blocks on the first line; the rest wait in the ring
+	cw.armed.Store(true)
+	ll.Info("msg 0")
+	deadline := time.Now().Add(5 * time.Second)
+	for x.ringLen() > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	// none of these may block
+	done := make(chan bool)
+	go func() {
+		for i := 1; i < 20; i++ {
+			ll.Info("msg %d", i)
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("ringbuffer: callers blocked on a slow writer")
+	}
+	assert(x.ringLen() == 8, "exp 8 lines in the ring, saw %d", x.ringLen())
+	assert(x.Dropped() == 11, "exp 11 dropped lines, saw %d", x.Dropped())
+
+	cw.armed.Store(false)
+	close(cw.release)
+
+	err = ll.Flush()
+	assert(err == nil, "flush: %s", err)
+
+	var exp strings.Builder
+	exp.WriteString("INFO: msg 0\n")
+	for i := 12; i < 20; i++ {
+		fmt.Fprintf(&exp, "INFO: msg %d\n", i)
+	}
+
+	cw.Lock()
+	out := cw.buf.String()
+	cw.Unlock()
+	assert(out == exp.String(), "exp:\n%s\nsaw:\n%s", exp.String(), out)
+
+	// turning the ring off goes back to the queue
+	x.SetRingBuffer(0)
+	ll.Info("after")
+	ll.Close()
+
+	cw.Lock()
+	out = cw.buf.String()
+	cw.Unlock()
+	assert(strings.HasSuffix(out, "msg 19\nINFO: after\n"), "line lost:\n%s", out)
+}
+
+func TestSynchronous(t *testing.T) {
+	assert := newAsserter(t, "sync")
+
+	var b bytes.Buffer
+	ll, err := NewWithOptions(&b, LOG_DEBUG, "", Llevelname, &Options{Synchronous: true})
+	assert(err == nil, "can't create log: %s", err)
+
+	// each line must be written before the call returns
+	for i := 0; i < 4; i++ {
+		ll.Info("line %d", i)
+		s := fmt.Sprintf("line %d\n", i)
+		assert(strings.HasSuffix(b.String(), s), "missing %q:\n%s", s, b.String())
+	}
+
+	sl := ll.New("sub", LOG_DEBUG)
+	sl.Warn("from sub")
+	assert(strings.HasSuffix(b.String(), "from sub\n"), "missing sub-logger line:\n%s", b.String())
+
+	w := ll.StdLogger()
+	w.Printf("from stdlog")
+	assert(strings.HasSuffix(b.String(), "from stdlog\n"), "missing stdlog line:\n%s", b.String())
+
+	ll.Close()
+	n := b.Len()
+	ll.Info("after close")
+	assert(b.Len() == n, "wrote after close:\n%s", b.String())
+}
+
+func TestLazyMessage(t *testing.T) {
+	assert := newAsserter(t, "lazy")
+
+	var b bytes.Buffer
+	ll, err := New(&b, LOG_INFO, "", Llevelname)
+	assert(err == nil, "can't create log: %s", err)
+
+	called := false
+	fn := func() string {
+		called = true
+		return "expensive"
+	}
+
+	ll.DebugFn(fn)
+	assert(!called, "closure called for a disabled level")
+
+	ll.InfoFn(fn)
+	assert(called, "closure not called for an enabled level")
+
+	ll.WarnFn(func() string { return "warned" })
+	ll.Close()
+
+	s := b.String()
+	assert(strings.Contains(s, "expensive\n"), "missing info line:\n%s", s)
+	assert(strings.Contains(s, "warned\n"), "missing warn line:\n%s", s)
+	assert(strings.Count(s, "expensive") == 1, "unexpected output:\n%s", s)
+
+	nl := NewNoneLogger(LOG_DEBUG, "")
+	called = false
+	nl.DebugFn(fn)
+	assert(!called, "closure called for the null logger")
+}
+
+func TestSanitize(t *testing.T) {
+	assert := newAsserter(t, "sanitize")
+
+	var b bytes.Buffer
+	ll, err := New(&b, LOG_DEBUG, "", Llevelname)
+	assert(err == nil, "can't create log: %s", err)
+
+	ll.Info("raw \x1b[31mred\x1b[0m")
+	ll.(*xLogger).SetSanitize(true)
+	ll.Info("esc \x1b[31mred\x1b[0m\x00 tab\there ok\xff é\n")
+	ll.Close()
+
+	s := b.String()
+	assert(strings.Contains(s, "raw \x1b[31mred"), "sanitized by default:\n%q", s)
+
+	exp := `esc \x1b[31mred\x1b[0m\x00 tab` + "\there ok" + `\xff` + " é\n"
+	assert(strings.Contains(s, exp), "exp %q:\n%q", exp, s)
+}
+
+func TestName(t *testing.T) {
+	assert := newAsserter(t, "name")
+	fn := filepath.Join(t.TempDir(), "app.log")
+
+	ll, err := NewFilelog(fn, LOG_INFO, "", 0)
+	assert(err == nil, "can't create log: %s", err)
+	defer ll.Close()
+
+	assert(ll.Name() == fn, "exp name %s, saw %s", fn, ll.Name())
+
+	// sub-loggers act on the log file of their parent
+	sub := ll.New("sub", LOG_DEBUG).(RotatableLogger)
+	assert(sub.Name() == fn, "sub-logger: exp name %s, saw %s", fn, sub.Name())
+	assert(sub.EnableRotation(0, 0, 0, 3) == nil, "sub-logger: can't enable rotation")
+	assert(sub.(*xLogger).SetReopenInterval(time.Hour) == nil, "sub-logger: can't set reopen interval")
+	assert(sub.(*xLogger).SyncEvery(time.Hour) == nil, "sub-logger: can't set sync interval")
+
+	sub.Info("before rotation")
+	err = sub.Rotate()
+	assert(err == nil, "sub-logger: rotate: %s", err)
+	sub.Info("after rotation")
+	assert(ll.Flush() == nil, "flush failed")
+
+	cur, err := os.ReadFile(fn)
+	assert(err == nil, "read log: %s", err)
+	assert(strings.Contains(string(cur), "after rotation\n"), "missing line:\n%s", cur)
+	assert(!strings.Contains(string(cur), "before rotation"), "not rotated:\n%s", cur)
+
+	nl, err := New(&bytes.Buffer{}, LOG_INFO, "", 0)
+	assert(err == nil, "can't create log: %s", err)
+	defer nl.Close()
+
+	assert(nl.(*xLogger).Name() == "", "non-file logger has a name: %s", nl.(*xLogger).Name())
+
+	ns := nl.New("sub", 0).(*xLogger)
+	assert(ns.Name() == "", "sub-logger of non-file logger has a name: %s", ns.Name())
+	assert(ns.Rotate() != nil, "sub-logger of non-file logger rotated")
+}
+
+// a writer that records Close()
+type closeWriter struct {
+	bytes.Buffer
+	closed int
+}
+
+func (w *closeWriter) Close() error {
+	w.closed++
+	return nil
+}
+
+func TestCloseWriter(t *testing.T) {
+	assert := newAsserter(t, "closewriter")
+
+	w := &closeWriter{}
+	ll, err := NewWithOptions(w, LOG_INFO, "", 0, &Options{CloseWriter: true})
+	assert(err == nil, "can't create log: %s", err)
+
+	sl := ll.New("sub", LOG_INFO)
+	sl.Info("from sub")
+	sl.Close()
+	assert(w.closed == 0, "sub-logger closed the writer")
+
+	ll.Info("hello")
+	ll.Close()
+	assert(w.closed == 1, "writer closed %d times", w.closed)
+	assert(strings.Contains(w.String(), "hello\n"), "pending line not written before close:\n%s", w.String())
+
+	// the writer isn't owned by default
+	w = &closeWriter{}
+	ll, err = New(w, LOG_INFO, "", 0)
+	assert(err == nil, "can't create log: %s", err)
+	ll.Close()
+	assert(w.closed == 0, "unowned writer closed")
+
+	_, err = NewWithOptions(&bytes.Buffer{}, LOG_INFO, "", 0, &Options{CloseWriter: true})
+	assert(err != nil, "expected error for a writer that can't be closed")
+}
+
+func TestSetPrio(t *testing.T) {
+	assert := newAsserter(t, "setprio")
+
+	var b bytes.Buffer
+	ll, err := New(&b, LOG_WARN, "", Llevelname)
+	assert(err == nil, "can't create log: %s", err)
+
+	db := ll.New("db", 0)
+	web := ll.New("web", 0)
+
+	// raise the verbosity of one sub-logger
+	db.(*xLogger).SetPrio(LOG_DEBUG)
+	assert(db.Prio() == LOG_DEBUG, "exp DEBUG, saw %s", db.Prio())
+	assert(ll.Prio() == LOG_WARN, "parent prio changed: %s", ll.Prio())
+	assert(db.Loggable(LOG_DEBUG), "child can't log DEBUG")
+	assert(!web.Loggable(LOG_DEBUG), "sibling can log DEBUG")
+
+	db.Debug("db debug")
+	web.Debug("web debug")
+	ll.Debug("parent debug")
+
+	// .. and lower it again
+	db.(*xLogger).SetPrio(LOG_ERR)
+	db.Warn("db warn")
+	ll.Close()
+
+	s := b.String()
+	assert(strings.Contains(s, "[db] db debug\n"), "missing child debug line:\n%s", s)
+	assert(!strings.Contains(s, "web debug"), "sibling logged debug:\n%s", s)
+	assert(!strings.Contains(s, "parent debug"), "parent logged debug:\n%s", s)
+	assert(!strings.Contains(s, "db warn"), "child logged below its level:\n%s", s)
+}
+
+func TestRegistry(t *testing.T) {
+	assert := newAsserter(t, "registry")
+
+	var b bytes.Buffer
+	ll, err := New(&b, LOG_INFO, "", Llevelname)
+	assert(err == nil, "can't create log: %s", err)
+
+	Register("test-registry", ll)
+
+	done := make(chan bool)
+	go func() {
+		l, ok := Lookup("test-registry")
+		if ok {
+			l.Info("from goroutine")
+		}
+		done <- ok
+	}()
+	assert(<-done, "lookup failed")
+	ll.Close()
+	assert(strings.Contains(b.String(), "from goroutine\n"), "missing line:\n%s", b.String())
+
+	l, ok := Lookup("test-missing")
+	assert(!ok, "lookup of a missing logger succeeded")
+	assert(l != nil, "missing logger is nil")
+	l.Info("discarded")
+
+	panics := func(fn func()) (p bool) {
+		defer func() { p = recover() != nil }()
+		fn()
+		return
+	}
+	assert(panics(func() { Register("test-registry", ll) }), "duplicate registration didn't panic")
+	assert(panics(func() { MustLookup("test-missing") }), "MustLookup didn't panic")
+	assert(MustLookup("test-registry") == ll, "MustLookup returned a different logger")
+}
+
+func TestErrChain(t *testing.T) {
+	assert := newAsserter(t, "errchain")
+
+	base := errors.New("disk full")
+	e1 := fmt.Errorf("write block: %w", base)
+	e2 := fmt.Errorf("flush: %w", e1)
+	e3 := fmt.Errorf("commit: %w", e2)
+
+	var b bytes.Buffer
+	ll, err := New(&b, LOG_INFO, "", Llevelname|Lerrchain)
+	assert(err == nil, "can't create log: %s", err)
+
+	ll.Error("failed: %v", e3)
+	ll.Info("count %d", 3)
+
+	ll.(*xLogger).SetErrorBacktrace(2)
+	ll.Error("again: %v", e1)
+	ll.Close()
+
+	s := b.String()
+	exp := "failed: commit: flush: write block: disk full\n" +
+		"\tflush: write block: disk full\n" +
+		"\twrite block: disk full\n" +
+		"\tdisk full\n"
+	assert(strings.Contains(s, exp), "missing error chain %q:\n%s", exp, s)
+	assert(strings.Contains(s, "count 3\n"), "missing plain line:\n%s", s)
+	assert(strings.Contains(s, "again: write block: disk full\n\tdisk full\n--backtrace"), "missing chain before backtrace:\n%s", s)
+}
+
+func TestSyslogOrStderr(t *testing.T) {
+	assert := newAsserter(t, "syslog-fallback")
+
+	syslogNew = func(p syslog.Priority, tag string) (*syslog.Writer, error) {
+		return nil, errors.New("connection refused")
+	}
+	defer func() { syslogNew = syslog.New }()
+
+	_, err := NewSyslog(LOG_INFO, "app", 0)
+	assert(err != nil, "expected syslog failure")
+
+	ll, err := NewSyslogOrStderr(LOG_INFO, "app", 0)
+	assert(err != nil, "expected the syslog error")
+	assert(strings.Contains(err.Error(), "connection refused"), "unexpected error: %s", err)
+	assert(ll != nil, "no fallback logger")
+
+	x := ll.(*xLogger)
+	assert(x.out == os.Stderr, "fallback doesn't write to stderr")
+	assert((x.Flags()&lSyslog) == 0, "fallback marked as syslog")
+	ll.Close()
+}
+
+func TestPrefixSeparator(t *testing.T) {
+	assert := newAsserter(t, "prefixsep")
+
+	var b bytes.Buffer
+	ll, err := New(&b, LOG_INFO, "app", Llevelname)
+	assert(err == nil, "can't create log: %s", err)
+
+	dot := ll.New("web", 0)
+	assert(dot.Prefix() == "[app.web] ", "default separator: %q", dot.Prefix())
+
+	ll.(*xLogger).SetPrefixSeparator("/")
+	pool := ll.New("db", 0).New("pool", 0)
+	assert(pool.Prefix() == "[app/db/pool] ", "exp [app/db/pool], saw %q", pool.Prefix())
+
+	pool.Info("hello")
+	ll.Close()
+	assert(strings.Contains(b.String(), "[app/db/pool] hello\n"), "missing line:\n%s", b.String())
+}
+
+func TestColumns(t *testing.T) {
+	assert := newAsserter(t, "columns")
+
+	var b bytes.Buffer
+	ll, err := New(&b, LOG_INFO, "app", Ldate|Ltime|Lfileloc|Lfunc|Lcolumns)
+	assert(err == nil, "can't create log: %s", err)
+
+	ll.Info("hello world")
+	ll.Close()
+
+	lines := strings.Split(b.String(), "\n")
+	assert(len(lines) > 2, "too few lines:\n%s", b.String())
+
+	f := strings.Fields(lines[1])
+	assert(len(f) == 8, "exp 8 columns, saw %d: %q", len(f), lines[1])
+	assert(f[0] == fmt.Sprintf("<%d>:", LOG_INFO), "prio: %q", f[0])
+	assert(re.MustCompile(`^\d{4}/\d{2}/\d{2}$`).MatchString(f[1]), "date: %q", f[1])
+	assert(re.MustCompile(`^\d{2}:\d{2}:\d{2}\.\d{3}$`).MatchString(f[2]), "time: %q", f[2])
+	assert(f[3] == "[app]", "prefix: %q", f[3])
+	assert(re.MustCompile(`^logger_test\.go:\d+$`).MatchString(f[4]), "file: %q", f[4])
+	assert(strings.HasSuffix(f[5], "TestColumns"), "func: %q", f[5])
+	assert(f[6] == "hello" && f[7] == "world", "message: %q", f[6:])
+	assert(strings.Count(lines[1], "  ") == 0, "double space in %q", lines[1])
+}
+
+func TestQuiet(t *testing.T) {
+	assert := newAsserter(t, "quiet")
+
+	var b bytes.Buffer
+	ll, err := NewWithOptions(&b, LOG_INFO, "", Llevelname, &Options{Quiet: true})
+	assert(err == nil, "can't create log: %s", err)
+
+	ll.Info("first")
+	ll.Close()
+
+	out, err := b.ReadString('\n')
+	assert(err == nil, "read: %s", err)
+	assert(strings.HasSuffix(out, " first\n"), "exp first message, saw %q", out)
+	assert(b.Len() == 0, "trailing lines: %q", b.String())
+}
+
+func TestFlushShared(t *testing.T) {
+	assert := newAsserter(t, "flush-shared")
+
+	w := &flushWriter{}
+	ll, err := NewWithOptions(w, LOG_INFO, "", Llevelname, &Options{QueueDepth: 64})
+	assert(err == nil, "can't create log: %s", err)
+
+	child := ll.New("child", 0)
+	for i := 0; i < 20; i++ {
+		ll.Info("parent %d", i)
+	}
+	child.Info("child")
+
+	err = child.Flush()
+	assert(err == nil, "flush: %s", err)
+
+	// the lines reach 'buf' only when the writer is flushed
+	s := w.String()
+	for i := 0; i < 20; i++ {
+		m := fmt.Sprintf("parent %d\n", i)
+		assert(strings.Contains(s, m), "missing %q:\n%s", m, s)
+	}
+	assert(strings.Contains(s, "[child] child\n"), "missing child line:\n%s", s)
+
+	ll.Close()
+	err = child.Flush()
+	assert(err != nil, "flush of a closed logger succeeded")
+}
+
+func TestItoa(t *testing.T) {
+	assert := newAsserter(t, "itoa")
+
+	tests := []struct {
+		i   int
+		wid int
+		exp string
+	}{
+		{0, 0, "0"},
+		{0, 1, "0"},
+		{0, 4, "0000"},
+		{0, -1, "0"},
+		{7, 2, "07"},
+		{2024, 4, "2024"},
+		{12345, 2, "12345"},
+		{-1, 0, "-1"},
+		{-7, 2, "-07"},
+		{-42, 4, "-0042"},
+		{-12345, 2, "-12345"},
+		{-5, -1, "-5"},
+		{math.MaxInt, 0, strconv.Itoa(math.MaxInt)},
+		{math.MinInt, 0, strconv.Itoa(math.MinInt)},
+		{3, 100, strings.Repeat("0", 30) + "3"},
+		{-3, 100, "-" + strings.Repeat("0", 30) + "3"},
+	}
+
+	for _, x := range tests {
+		s := string(itoa([]byte("x"), x.i, x.wid))
+		assert(s == "x"+x.exp, "itoa(%d, %d): exp %q, saw %q", x.i, x.wid, x.exp, s[1:])
+	}
+}
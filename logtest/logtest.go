@@ -0,0 +1,88 @@
+// logtest.go - capture log lines in tests
+//
+// Copyright 2012, Sudhi Herle <sudhi -at- herle.net>
+// This code is licensed under the same terms as the golang core.
+
+// Package logtest provides a logger that captures its output for
+// assertions in tests.
+package logtest
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+
+	logger "github.com/opencoff/go-logger"
+)
+
+// Capture holds the lines written by a logger created via NewCapture()
+type Capture struct {
+	mu    sync.Mutex
+	lines []string
+	part  []byte // incomplete last line
+}
+
+// NewCapture creates a logger at level 'prio' whose output is captured
+// in the returned Capture. Each captured line is of the form
+// "LEVEL: message" (e.g., "INFO: hello") without the trailing newline;
+// the lines logged when the logger starts and closes are suppressed.
+// The logger writes synchronously: a line is captured before the log
+// call returns.
+func NewCapture(prio logger.Priority) (logger.Logger, *Capture) {
+	c := &Capture{}
+	opt := &logger.Options{
+		Synchronous: true,
+		Quiet:       true,
+	}
+
+	// a Capture is never nil; this can't fail
+	ll, _ := logger.NewWithOptions(c, prio, "", logger.Llevelname, opt)
+	return ll, c
+}
+
+// Write implements io.Writer for the logger
+func (c *Capture) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := len(b)
+	if len(c.part) > 0 {
+		b = append(c.part, b...)
+		c.part = nil
+	}
+
+	for {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			break
+		}
+		c.lines = append(c.lines, string(b[:i]))
+		b = b[i+1:]
+	}
+	if len(b) > 0 {
+		c.part = append([]byte(nil), b...)
+	}
+	return n, nil
+}
+
+// Lines returns a copy of the captured lines
+func (c *Capture) Lines() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.lines...)
+}
+
+// Contains returns true if any captured line contains 'substr'
+func (c *Capture) Contains(substr string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, s := range c.lines {
+		if strings.Contains(s, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+// vim: ft=go:sw=8:ts=8:noexpandtab:tw=98:
@@ -0,0 +1,36 @@
+package logtest
+
+import (
+	"testing"
+
+	logger "github.com/opencoff/go-logger"
+)
+
+func TestCapture(t *testing.T) {
+	ll, c := NewCapture(logger.LOG_INFO)
+	defer ll.Close()
+
+	ll.Info("one")
+	ll.Warn("two")
+	ll.Debug("filtered")
+	ll.New("sub", 0).Error("three")
+
+	lines := c.Lines()
+	if len(lines) != 3 {
+		t.Fatalf("exp 3 lines, saw %d: %q", len(lines), lines)
+	}
+
+	exp := []string{"INFO: one", "WARNING: two", "ERROR: [sub] three"}
+	for i, s := range exp {
+		if lines[i] != s {
+			t.Errorf("line %d: exp %q, saw %q", i, s, lines[i])
+		}
+	}
+
+	if !c.Contains("two") {
+		t.Errorf("Contains missed a line")
+	}
+	if c.Contains("filtered") {
+		t.Errorf("captured a filtered line")
+	}
+}
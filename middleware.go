@@ -0,0 +1,69 @@
+// middleware.go - wrap the log destination with caller supplied writers
+//
+// Copyright 2012, Sudhi Herle <sudhi -at- herle.net>
+// This code is licensed under the same terms as the golang core.
+
+package logger
+
+import (
+	"io"
+)
+
+// the destination wrapped in the write middleware; only accessed by the
+// writer.
+type mwcache struct {
+	mw *[]func(io.Writer) io.Writer // middleware list 'w' was built from
+	w  io.Writer
+}
+
+// AddWriteMiddleware wraps the destination of the logger with the
+// writer returned by 'fn': every log line is written to that writer
+// instead, which in turn writes to the destination. Middleware is
+// applied in the order it is added; the last one added sees the writes
+// first. The rest of the logger (rotation, reopen, flush and close) works
+// on the destination itself: when the log file is rotated or reopened,
+// 'fn' is called again with the new file. Middleware is shared by all
+// sub-loggers.
+func (l *xLogger) AddWriteMiddleware(fn func(io.Writer) io.Writer) {
+	l.ch.mmu.Lock()
+	defer l.ch.mmu.Unlock()
+
+	// copy-on-write: the writer reads the list without a lock
+	var mw []func(io.Writer) io.Writer
+	if old := l.ch.mw.Load(); old != nil {
+		mw = append(mw, *old...)
+	}
+	mw = append(mw, fn)
+	l.ch.mw.Store(&mw)
+}
+
+// return the destination wrapped in the write middleware (if any); this
+// must only be called by the writer.
+func (l *xLogger) writer() io.Writer {
+	mw := l.ch.mw.Load()
+	if mw == nil {
+		return l.out
+	}
+
+	c := &l.ch.mwc
+	if c.mw != mw || c.w == nil {
+		w := l.out
+		for _, fn := range *mw {
+			w = fn(w)
+		}
+		c.mw, c.w = mw, w
+	}
+	return c.w
+}
+
+// set the destination to 'w'; this must only be called by the writer.
+func (l *xLogger) setOut(w io.Writer) {
+	l.mu.Lock()
+	l.out = w
+	l.mu.Unlock()
+
+	// the middleware must wrap the new destination
+	l.ch.mwc = mwcache{}
+}
+
+// vim: ft=go:sw=8:ts=8:noexpandtab:tw=98:
@@ -0,0 +1,121 @@
+// options.go - tunables for a logger instance
+//
+// Copyright 2012, Sudhi Herle <sudhi -at- herle.net>
+// This code is licensed under the same terms as the golang core.
+
+package logger
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+)
+
+// Options holds the tunables for a logger instance created via
+// NewWithOptions() or NewFilelogWithOptions(). The zero value of each
+// field selects the default.
+type Options struct {
+	// QueueDepth is the number of log lines that can be queued for the
+	// I/O goroutine before callers block; it defaults to the number of
+	// CPUs. A deeper queue trades memory for tolerance of bursts.
+	QueueDepth int
+
+	// BufSize is the initial capacity of the buffers used to format log
+	// lines; it defaults to 256 bytes. Programs that log long lines can
+	// avoid regrowing the buffers by raising it. Buffers that grow
+	// beyond 16 times this size aren't reused.
+	BufSize int
+
+	// Batch coalesces the log lines queued for the I/O goroutine into a
+	// single write (of upto 64KB) to the destination: this cuts the
+	// number of system calls when the logger is busy. Lines are written
+	// as soon as the queue is empty, so a quiet logger doesn't delay
+	// them.
+	Batch bool
+
+	// Synchronous writes each log line to the destination before the
+	// logging call returns, instead of queueing it for the I/O
+	// goroutine. Output is then ordered with respect to other writes by
+	// the caller (e.g., to stdout) and needn't be flushed via Close();
+	// callers pay for the I/O. Suppressed repeats are reported with the
+	// next line that differs or on Close().
+	Synchronous bool
+
+	// CloseWriter transfers the ownership of the output writer of
+	// NewWithOptions() to the logger: closing the logger closes the
+	// writer once the queued log lines are written. The writer must be
+	// an io.WriteCloser. Sub-loggers and clones never close it. Log
+	// files are always closed by the logger.
+	CloseWriter bool
+
+	// Quiet suppresses the informational lines logged when the logger
+	// starts and when it is closed.
+	Quiet bool
+}
+
+// return the queue depth with the defaults applied
+func (o *Options) queueDepth() int {
+	if o == nil || o.QueueDepth <= 0 {
+		return runtime.NumCPU()
+	}
+	return o.QueueDepth
+}
+
+// return the buffer size with the defaults applied
+func (o *Options) bufSize() int {
+	if o == nil || o.BufSize <= 0 {
+		return _LOGBUFSZ
+	}
+	return o.BufSize
+}
+
+// return true if queued lines must be batched
+func (o *Options) batch() bool {
+	return o != nil && o.Batch
+}
+
+// return true if log lines must be written synchronously
+func (o *Options) synchronous() bool {
+	return o != nil && o.Synchronous
+}
+
+// return true if the logger owns the output writer
+func (o *Options) closeWriter() bool {
+	return o != nil && o.CloseWriter
+}
+
+// return true if the start and close lines must be suppressed
+func (o *Options) quiet() bool {
+	return o != nil && o.Quiet
+}
+
+// NewWithOptions is like New() but also applies the tunables in 'opt';
+// a nil 'opt' selects the defaults.
+func NewWithOptions(out io.Writer, prio Priority, prefix string, flag int, opt *Options) (Logger, error) {
+	if out == nil {
+		return nil, fmt.Errorf("%s: logger: nil output writer", prefix)
+	}
+
+	ll := makeLogger(out, prio, prefix, defaultFlag(flag), opt)
+	if opt.closeWriter() {
+		if _, ok := out.(io.WriteCloser); !ok {
+			return nil, fmt.Errorf("%s: logger: output writer can't be closed", prefix)
+		}
+		ll.flag |= lClose
+	}
+	ll.run()
+	return ll, nil
+}
+
+// NewFilelogWithOptions is like NewFilelog() but also applies the
+// tunables in 'opt'; a nil 'opt' selects the defaults.
+func NewFilelogWithOptions(file string, prio Priority, prefix string, flag int, opt *Options) (RotatableLogger, error) {
+	ll, err := newFilelog(file, prio, prefix, flag, os.O_TRUNC, opt)
+	if err != nil {
+		return nil, err
+	}
+	return ll, nil
+}
+
+// vim: ft=go:sw=8:ts=8:noexpandtab:tw=98:
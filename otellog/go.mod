@@ -0,0 +1,11 @@
+module github.com/opencoff/go-logger/otellog
+
+go 1.25.0
+
+require (
+	github.com/opencoff/go-logger v0.0.0-20261014122941-6ff4079c3866
+	go.opentelemetry.io/otel v1.46.0
+	go.opentelemetry.io/otel/log v0.22.0
+)
+
+require github.com/cespare/xxhash/v2 v2.3.0 // indirect
@@ -0,0 +1,121 @@
+// otellog.go - bridge log messages to OpenTelemetry log records
+//
+// Copyright 2012, Sudhi Herle <sudhi -at- herle.net>
+// This code is licensed under the same terms as the golang core.
+
+// Package otellog bridges go-logger to OpenTelemetry: each log message
+// becomes an OTel log record. It is a separate module so that the logger
+// itself has no dependency on OpenTelemetry.
+package otellog
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	logger "github.com/opencoff/go-logger"
+	"go.opentelemetry.io/otel/attribute"
+	otel "go.opentelemetry.io/otel/log"
+)
+
+// name of the instrumentation scope of the records
+const scope = "github.com/opencoff/go-logger"
+
+// map of logger priorities to OTel severities; this follows the
+// mapping of syslog severities in Appendix B of the OTel log data model.
+var severity = map[logger.Priority]otel.Severity{
+	logger.LOG_DEBUG:  otel.SeverityDebug,
+	logger.LOG_INFO:   otel.SeverityInfo,
+	logger.LOG_NOTICE: otel.SeverityInfo2,
+	logger.LOG_WARN:   otel.SeverityWarn,
+	logger.LOG_ERR:    otel.SeverityError,
+	logger.LOG_CRIT:   otel.SeverityError2,
+	logger.LOG_ALERT:  otel.SeverityError3,
+	logger.LOG_EMERG:  otel.SeverityFatal,
+}
+
+// NewOtelLogger creates a new logger instance at LOG_DEBUG that emits
+// each log message as a record of the OTel logger provided by 'p'. The
+// severity of the record is mapped from the message priority, the body
+// is the message (with the logger prefix) and the fields attached via
+// WithFields() become the attributes of the record. With Lfileloc, the
+// source location is added as the code.* attributes. The logger is
+// quiet: the open and close banners aren't exported.
+//
+// Sub-loggers created via New() can log at a different priority.
+func NewOtelLogger(p otel.LoggerProvider) logger.Logger {
+	ll, _ := logger.NewWithOptions(io.Discard, logger.LOG_DEBUG, "", 0, &logger.Options{Quiet: true})
+	ll.(interface{ SetFormatter(logger.Formatter) }).SetFormatter(&bridge{p.Logger(scope)})
+	return ll
+}
+
+// bridge is a Formatter that emits OTel records instead of rendering
+// the event
+type bridge struct {
+	l otel.Logger
+}
+
+func (o *bridge) Format(b []byte, e logger.Event) []byte {
+	var r otel.Record
+
+	r.SetTimestamp(e.Time)
+	r.SetObservedTimestamp(time.Now())
+	r.SetSeverity(severity[e.Prio])
+	r.SetSeverityText(e.Prio.String())
+	r.SetBody(attribute.StringValue(e.Prefix + string(e.Msg)))
+
+	for i := range e.Fields {
+		f := &e.Fields[i]
+		r.AddAttributes(attribute.KeyValue{Key: attribute.Key(f.Key), Value: value(f.Value)})
+	}
+
+	if len(e.File) > 0 {
+		r.AddAttributes(attribute.String("code.filepath", e.File), attribute.Int("code.lineno", e.Line))
+		if len(e.Func) > 0 {
+			r.AddAttributes(attribute.String("code.function", e.Func))
+		}
+	}
+
+	o.l.Emit(context.Background(), r)
+	return b
+}
+
+// convert a field value to an OTel value
+func value(v any) attribute.Value {
+	switch x := v.(type) {
+	case string:
+		return attribute.StringValue(x)
+	case bool:
+		return attribute.BoolValue(x)
+	case int:
+		return attribute.IntValue(x)
+	case int8:
+		return attribute.Int64Value(int64(x))
+	case int16:
+		return attribute.Int64Value(int64(x))
+	case int32:
+		return attribute.Int64Value(int64(x))
+	case int64:
+		return attribute.Int64Value(x)
+	case uint8:
+		return attribute.Int64Value(int64(x))
+	case uint16:
+		return attribute.Int64Value(int64(x))
+	case uint32:
+		return attribute.Int64Value(int64(x))
+	case float32:
+		return attribute.Float64Value(float64(x))
+	case float64:
+		return attribute.Float64Value(x)
+	case []byte:
+		return attribute.ByteSliceValue(x)
+	case error:
+		return attribute.StringValue(x.Error())
+	case fmt.Stringer:
+		return attribute.StringValue(x.String())
+	}
+	return attribute.StringValue(fmt.Sprint(v))
+}
+
+// vim: ft=go:sw=8:ts=8:noexpandtab:tw=98:
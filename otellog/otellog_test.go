@@ -0,0 +1,127 @@
+package otellog
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	logger "github.com/opencoff/go-logger"
+	"go.opentelemetry.io/otel/attribute"
+	otel "go.opentelemetry.io/otel/log"
+	"go.opentelemetry.io/otel/log/embedded"
+)
+
+// in-memory OTel logger provider
+type provider struct {
+	embedded.LoggerProvider
+	r *recorder
+}
+
+func (p *provider) Logger(name string, opts ...otel.LoggerOption) otel.Logger {
+	return p.r
+}
+
+// OTel logger that records the emitted records
+type recorder struct {
+	embedded.Logger
+
+	sync.Mutex
+	recs []otel.Record
+}
+
+func (r *recorder) Emit(ctx context.Context, rec otel.Record) {
+	r.Lock()
+	r.recs = append(r.recs, rec.Clone())
+	r.Unlock()
+}
+
+func (r *recorder) Enabled(ctx context.Context, p otel.EnabledParameters) bool {
+	return true
+}
+
+func TestOtelLogger(t *testing.T) {
+	r := &recorder{}
+	ll := NewOtelLogger(&provider{r: r})
+
+	ll.Debug("debug")
+	ll.Info("info")
+	ll.Notice("notice")
+	ll.Warn("warn")
+	ll.WithFields(map[string]any{"user": "bob", "n": 3}).Error("oops")
+	ll.Crit("crit")
+	ll.Alert("alert")
+	ll.Close()
+
+	exp := []struct {
+		body string
+		sev  otel.Severity
+		prio logger.Priority
+	}{
+		{"debug", otel.SeverityDebug, logger.LOG_DEBUG},
+		{"info", otel.SeverityInfo, logger.LOG_INFO},
+		{"notice", otel.SeverityInfo2, logger.LOG_NOTICE},
+		{"warn", otel.SeverityWarn, logger.LOG_WARN},
+		{"oops", otel.SeverityError, logger.LOG_ERR},
+		{"crit", otel.SeverityError2, logger.LOG_CRIT},
+		{"alert", otel.SeverityError3, logger.LOG_ALERT},
+	}
+
+	// the logger is quiet: there are no banners
+	recs := r.recs
+	if len(recs) != len(exp) {
+		t.Fatalf("exp %d records, saw %d", len(exp), len(recs))
+	}
+
+	for i, x := range exp {
+		rec := &recs[i]
+		if s := rec.Body().AsString(); s != x.body {
+			t.Errorf("%d: exp body %q, saw %q", i, x.body, s)
+		}
+		if rec.Severity() != x.sev {
+			t.Errorf("%s: exp severity %s, saw %s", x.body, x.sev, rec.Severity())
+		}
+		if s := rec.SeverityText(); s != x.prio.String() {
+			t.Errorf("%s: exp severity text %s, saw %s", x.body, x.prio, s)
+		}
+	}
+
+	attrs := make(map[string]any)
+	recs[4].WalkAttributes(func(kv attribute.KeyValue) bool {
+		attrs[string(kv.Key)] = kv.Value.AsInterface()
+		return true
+	})
+	if attrs["user"] != "bob" || attrs["n"] != int64(3) {
+		t.Errorf("unexpected attributes: %v", attrs)
+	}
+}
+
+func TestSeverity(t *testing.T) {
+	exp := []struct {
+		prio logger.Priority
+		sev  otel.Severity
+	}{
+		{logger.LOG_DEBUG, otel.SeverityDebug},
+		{logger.LOG_INFO, otel.SeverityInfo},
+		{logger.LOG_NOTICE, otel.SeverityInfo2},
+		{logger.LOG_WARN, otel.SeverityWarn},
+		{logger.LOG_ERR, otel.SeverityError},
+		{logger.LOG_CRIT, otel.SeverityError2},
+		{logger.LOG_ALERT, otel.SeverityError3},
+		{logger.LOG_EMERG, otel.SeverityFatal},
+	}
+
+	r := &recorder{}
+	b := &bridge{r}
+	for _, x := range exp {
+		b.Format(nil, logger.Event{Prio: x.prio, Msg: []byte("x")})
+	}
+
+	if len(r.recs) != len(exp) {
+		t.Fatalf("exp %d records, saw %d", len(exp), len(r.recs))
+	}
+	for i, x := range exp {
+		if sev := r.recs[i].Severity(); sev != x.sev {
+			t.Errorf("%s: exp severity %s, saw %s", x.prio, x.sev, sev)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+// overflow.go - handling of a full log queue
+//
+// Copyright 2012, Sudhi Herle <sudhi -at- herle.net>
+// This code is licensed under the same terms as the golang core.
+
+package logger
+
+// OverflowPolicy describes what happens to a log line when the queue of
+// the I/O goroutine is full.
+type OverflowPolicy int
+
+const (
+	// Block makes the caller wait until the queue has room (default)
+	Block OverflowPolicy = iota
+
+	// DropNewest discards the incoming line
+	DropNewest
+
+	// DropOldest discards the oldest queued line to make room for the
+	// incoming one; the most recent lines survive a burst.
+	DropOldest
+)
+
+// SetOverflowPolicy sets what happens to log lines when the queue is
+// full. The policy is shared by all sub-loggers. Control requests (e.g.,
+// Rotate()) have a queue of their own and are never discarded; they are
+// handled after the log lines queued before them.
+func (l *xLogger) SetOverflowPolicy(p OverflowPolicy) {
+	l.ch.overflow.Store(int32(p))
+}
+
+// Dropped returns the number of log lines discarded because the queue
+// was full.
+func (l *xLogger) Dropped() uint64 {
+	return l.ch.drops.Load()
+}
+
+// QueueLen returns the number of events waiting in the queue of the
+// I/O goroutine. It is an instantaneous snapshot: the queue may have
+// drained or filled by the time the caller looks at it.
+func (l *xLogger) QueueLen() int {
+	return len(l.ch.logch)
+}
+
+// QueueCap returns the capacity of the queue of the I/O goroutine
+// (see Options.QueueDepth).
+func (l *xLogger) QueueCap() int {
+	return cap(l.ch.logch)
+}
+
+// queue the log line 'e' as per the overflow policy; returns false if
+// the logger was closed while waiting for room in the queue. The caller
+// must hold l.ch.qmu for reading.
+func (l *xLogger) enqueue(e qev) bool {
+	if r := l.ch.ring.Load(); r != nil && r.push(e, l) {
+		return true
+	}
+
+	switch OverflowPolicy(l.ch.overflow.Load()) {
+	case DropNewest:
+		select {
+		case l.ch.logch <- e:
+		default:
+			l.putBuf(e.buf)
+			l.ch.drops.Add(1)
+		}
+		return true
+
+	case DropOldest:
+		for {
+			select {
+			case l.ch.logch <- e:
+				return true
+			default:
+			}
+
+			// evict the oldest pending event and retry; the
+			// queue may have drained in the meantime.
+			select {
+			case old := <-l.ch.logch:
+				l.putBuf(old.buf)
+				l.ch.drops.Add(1)
+			default:
+			}
+		}
+
+	default:
+		select {
+		case l.ch.logch <- e:
+			return true
+		case <-l.ch.quit:
+			l.putBuf(e.buf)
+			return false
+		}
+	}
+}
+
+// vim: ft=go:sw=8:ts=8:noexpandtab:tw=98:
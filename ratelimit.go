@@ -0,0 +1,73 @@
+// ratelimit.go - rate limiting of log lines
+//
+// Copyright 2012, Sudhi Herle <sudhi -at- herle.net>
+// This code is licensed under the same terms as the golang core.
+
+package logger
+
+import (
+	"sync"
+	"time"
+)
+
+// a fixed window rate limiter
+type ratelimit struct {
+	sync.Mutex
+	n   int           // max messages per window
+	per time.Duration // window size
+
+	start time.Time // current window start
+	count int       // messages seen in the current window
+	drops int       // messages suppressed in the current window
+}
+
+// SetRateLimit limits this logger to at most 'n' messages every 'per'
+// interval; messages beyond the limit are dropped before they are
+// formatted. When a new interval starts, a summary of the number of
+// suppressed messages is logged. A value of n <= 0 removes the limit.
+//
+// Sub-loggers created after this call share the limit with this logger;
+// they can set their own limit independently.
+func (l *xLogger) SetRateLimit(n int, per time.Duration) {
+	if n <= 0 || per <= 0 {
+		l.rl.Store(nil)
+		return
+	}
+
+	l.rl.Store(&ratelimit{n: n, per: per})
+}
+
+// return true if rate limiting allows a message to be logged now
+func (l *xLogger) allow() bool {
+	r := l.rl.Load()
+	if r == nil {
+		return true
+	}
+
+	now := l.clock()
+
+	r.Lock()
+	if now.Sub(r.start) >= r.per {
+		drops := r.drops
+		r.start = now
+		r.count = 0
+		r.drops = 0
+		r.Unlock()
+
+		if drops > 0 {
+			l.Output(0, LOG_WARN, "logger: rate limit: suppressed %d messages", drops)
+		}
+		r.Lock()
+	}
+
+	ok := r.count < r.n
+	if ok {
+		r.count++
+	} else {
+		r.drops++
+	}
+	r.Unlock()
+	return ok
+}
+
+// vim: ft=go:sw=8:ts=8:noexpandtab:tw=98:
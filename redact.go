@@ -0,0 +1,51 @@
+// redact.go - scrub sensitive text from log messages
+//
+// Copyright 2012, Sudhi Herle <sudhi -at- herle.net>
+// This code is licensed under the same terms as the golang core.
+
+package logger
+
+import (
+	"regexp"
+)
+
+// a registered redaction
+type redactor struct {
+	re   *regexp.Regexp
+	repl []byte
+}
+
+// AddRedactor registers a redaction that replaces every match of 're' in
+// a log message (and its fields) with 'replacement' before the message is
+// written; 'replacement' may refer to submatches as in
+// regexp.Regexp.Expand(). Redactors are applied in the order they are
+// registered and are shared by all sub-loggers.
+func (l *xLogger) AddRedactor(re *regexp.Regexp, replacement string) {
+	l.ch.rmu.Lock()
+	defer l.ch.rmu.Unlock()
+
+	// copy-on-write: ofmt() reads the list without a lock
+	var rv []redactor
+	if old := l.ch.redact.Load(); old != nil {
+		rv = append(rv, *old...)
+	}
+	rv = append(rv, redactor{re, []byte(replacement)})
+	l.ch.redact.Store(&rv)
+}
+
+// apply the redactors to b[off:]
+func (l *xLogger) redact(b []byte, off int) []byte {
+	rv := l.ch.redact.Load()
+	if rv == nil {
+		return b
+	}
+
+	s := b[off:]
+	for i := range *rv {
+		r := &(*rv)[i]
+		s = r.re.ReplaceAll(s, r.repl)
+	}
+	return append(b[:off], s...)
+}
+
+// vim: ft=go:sw=8:ts=8:noexpandtab:tw=98:
@@ -0,0 +1,59 @@
+// registry.go - registry of named loggers
+//
+// Copyright 2012, Sudhi Herle <sudhi -at- herle.net>
+// This code is licensed under the same terms as the golang core.
+
+package logger
+
+import (
+	"fmt"
+	"sync"
+)
+
+var (
+	regMu    sync.RWMutex
+	registry = make(map[string]Logger)
+)
+
+// Register makes the logger 'l' available by 'name' to Lookup() - e.g.,
+// for packages that can't be handed a logger by their caller. If l is
+// nil or Register is called twice with the same name, it panics.
+func Register(name string, l Logger) {
+	if l == nil {
+		panic("logger: Register logger is nil")
+	}
+
+	regMu.Lock()
+	defer regMu.Unlock()
+
+	if _, ok := registry[name]; ok {
+		panic(fmt.Sprintf("logger: Register called twice for %q", name))
+	}
+	registry[name] = l
+}
+
+// Lookup returns the logger registered as 'name'. If there is no such
+// logger, it returns a logger that discards everything and false; the
+// result is always safe to use.
+func Lookup(name string) (Logger, bool) {
+	regMu.RLock()
+	l, ok := registry[name]
+	regMu.RUnlock()
+
+	if !ok {
+		return newNullLogger("", LOG_NONE), false
+	}
+	return l, true
+}
+
+// MustLookup is like Lookup but panics if there is no logger registered
+// as 'name'.
+func MustLookup(name string) Logger {
+	l, ok := Lookup(name)
+	if !ok {
+		panic(fmt.Sprintf("logger: no logger registered as %q", name))
+	}
+	return l
+}
+
+// vim: ft=go:sw=8:ts=8:noexpandtab:tw=98:
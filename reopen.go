@@ -0,0 +1,111 @@
+// reopen.go - reopen a log file that was deleted or renamed
+//
+// Copyright 2012, Sudhi Herle <sudhi -at- herle.net>
+// This code is licensed under the same terms as the golang core.
+
+package logger
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// SetReopenInterval enables a periodic check - every 'd' - of the log
+// file: if it was deleted or renamed (e.g., by an external log rotator),
+// the logger reopens the file by name and continues writing to it. This
+// is similar to 'tail -F'. A value of d <= 0 disables the check (the
+// default). The setting is shared by all sub-loggers.
+func (l *xLogger) SetReopenInterval(d time.Duration) error {
+	// the log file belongs to the root logger
+	l = l.ch.root
+	if len(l.name) == 0 {
+		return fmt.Errorf("%s: logger is not file backed", l.Prefix())
+	}
+
+	if d < 0 {
+		d = 0
+	}
+	l.ch.rwait.Store(int64(d))
+	if !l.qevent(qev{ty: _QEV_REOPEN}) {
+		return fmt.Errorf("%s: logger is closed", l.Prefix())
+	}
+	return nil
+}
+
+// return the channel that fires when the log file must be checked
+func (l *xLogger) reopenTick() <-chan time.Time {
+	if l.ch.reopen == nil {
+		return nil
+	}
+	return l.ch.reopen.C
+}
+
+// restart the periodic check with the current interval. Called only
+// from qrunner.
+func (l *xLogger) resetReopen() {
+	if l.ch.reopen != nil {
+		l.ch.reopen.Stop()
+		l.ch.reopen = nil
+	}
+
+	if d := time.Duration(l.ch.rwait.Load()); d > 0 {
+		l.ch.reopen = time.NewTicker(d)
+	}
+}
+
+// reopen the log file if it is no longer the one at 'l.name'. Called
+// only from qrunner.
+func (l *xLogger) reopenLog() {
+	l.mu.Lock()
+	isfile := (l.flag & lClose) != 0
+	l.mu.Unlock()
+
+	fd, ok := l.out.(*os.File)
+	if !isfile || !ok {
+		return
+	}
+
+	cur, err := fd.Stat()
+	if err != nil {
+		l.ioError(fmt.Errorf("logger: %s stat: %w", l.name, err))
+		return
+	}
+
+	if fi, err := os.Stat(l.name); err == nil && os.SameFile(cur, fi) {
+		return
+	}
+
+	nfd, err := os.OpenFile(l.name, os.O_WRONLY|os.O_CREATE|os.O_APPEND|l.syncFlag(), 0600)
+	if err != nil {
+		l.ioError(fmt.Errorf("logger: %s reopen: %w", l.name, err))
+		return
+	}
+
+	fd.Close()
+	l.setOut(nfd)
+	l.staleClones()
+
+	// the new file must start with a full time stamp
+	l.ch.relbase = false
+	l.dprintf(0, LOG_INFO, "Log file %s reopened.", l.name)
+}
+
+// tell the clones of this logger that the log file was replaced; they
+// reopen it before their next write. Closed clones are forgotten.
+func (l *xLogger) staleClones() {
+	l.ch.clmu.Lock()
+	defer l.ch.clmu.Unlock()
+
+	cl := l.ch.clones[:0]
+	for _, c := range l.ch.clones {
+		if !c.ch.closed.Load() {
+			c.ch.stale.Store(true)
+			cl = append(cl, c)
+		}
+	}
+	clear(l.ch.clones[len(cl):])
+	l.ch.clones = cl
+}
+
+// vim: ft=go:sw=8:ts=8:noexpandtab:tw=98:
@@ -0,0 +1,60 @@
+//go:build unix
+
+package logger
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReopen(t *testing.T) {
+	assert := newAsserter(t, "reopen")
+	fn := filepath.Join(t.TempDir(), "app.log")
+
+	ll, err := NewFilelog(fn, LOG_INFO, "", 0)
+	assert(err == nil, "can't create log: %s", err)
+
+	x := ll.(*xLogger)
+	err = x.SetReopenInterval(10 * time.Millisecond)
+	assert(err == nil, "reopen interval: %s", err)
+
+	ll.Info("before remove")
+	err = os.Remove(fn)
+	assert(err == nil, "rm: %s", err)
+
+	// wait for the logger to notice
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(fn); err == nil {
+			break
+		}
+		assert(time.Now().Before(deadline), "%s not reopened", fn)
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	ll.Info("after remove")
+	assert(ll.Name() == fn, "name changed after reopen: %s", ll.Name())
+	ll.Close()
+
+	b, err := os.ReadFile(fn)
+	assert(err == nil, "read: %s", err)
+
+	s := string(b)
+	assert(strings.Contains(s, "reopened"), "missing reopen msg:\n%s", s)
+	assert(strings.Contains(s, "after remove"), "missing new log:\n%s", s)
+	assert(!strings.Contains(s, "before remove"), "old log in new file:\n%s", s)
+}
+
+func TestReopenNotFile(t *testing.T) {
+	assert := newAsserter(t, "reopen-nofile")
+
+	ll, err := New(os.Stderr, LOG_INFO, "", 0)
+	assert(err == nil, "can't create log: %s", err)
+	defer ll.Close()
+
+	err = ll.(*xLogger).SetReopenInterval(time.Second)
+	assert(err != nil, "expected error for non-file logger")
+}
@@ -0,0 +1,33 @@
+package logger
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	re "regexp"
+	"strings"
+	"testing"
+)
+
+func TestRFC5424(t *testing.T) {
+	assert := newAsserter(t, "rfc5424")
+
+	var b bytes.Buffer
+	ll, err := NewRFC5424(&b, LOG_INFO, "foo")
+	assert(err == nil, "can't create log: %s", err)
+
+	ll.Info("hello world")
+	ll.WithFields(map[string]any{"user": "bob", "q": `a"]b`}).Error("oops")
+	ll.Close()
+
+	lines := strings.Split(b.String(), "\n")
+	assert(len(lines) == 5, "exp 4 lines; saw:\n%s", b.String())
+
+	hdr := fmt.Sprintf(`^<%%d>1 \d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{6}Z \S+ \S+ %d - `, os.Getpid())
+
+	rx := re.MustCompile(fmt.Sprintf(hdr, 30) + `- \[foo\] hello world$`)
+	assert(rx.MatchString(lines[1]), "info frame mismatch: %q", lines[1])
+
+	rx = re.MustCompile(fmt.Sprintf(hdr, 27) + `\[fields@32473 q="a\\"\\]b" user="bob"\] \[foo\] oops$`)
+	assert(rx.MatchString(lines[2]), "error frame mismatch: %q", lines[2])
+}
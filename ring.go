@@ -0,0 +1,151 @@
+// ring.go - bounded ring buffer of log lines for slow writers
+//
+// Copyright 2012, Sudhi Herle <sudhi -at- herle.net>
+// This code is licensed under the same terms as the golang core.
+
+package logger
+
+import (
+	"sync"
+)
+
+// ring is a bounded FIFO of log lines between the callers and qrunner
+type ring struct {
+	sync.Mutex
+	ev   []qev // circular buffer
+	head int   // index of the oldest event
+	n    int   // number of events
+	max  int   // capacity; 0 if the ring is off
+
+	wake chan struct{} // signals qrunner of new events
+}
+
+// SetRingBuffer queues log lines in a ring buffer of 'capacity' lines
+// instead of the queue of the I/O goroutine: a slow or blocked writer
+// never blocks the callers. The buffered lines are written in order
+// once the writer recovers; when the ring is full, the oldest line is
+// discarded (and counted by Dropped()). A capacity <= 0 turns the ring
+// buffer off; lines already in the ring are still written. The ring is
+// shared by all sub-loggers; it has no effect on synchronous loggers.
+func (l *xLogger) SetRingBuffer(capacity int) {
+	if capacity < 0 {
+		capacity = 0
+	}
+
+	r := l.ch.ring.Load()
+	if r == nil {
+		if capacity == 0 {
+			return
+		}
+
+		r = &ring{wake: make(chan struct{}, 1)}
+		if !l.ch.ring.CompareAndSwap(nil, r) {
+			r = l.ch.ring.Load()
+		}
+	}
+
+	r.Lock()
+	r.resize(capacity, l)
+	r.Unlock()
+}
+
+// change the capacity of the ring to 'max' - keeping the newest events;
+// the caller must hold the lock.
+func (r *ring) resize(size int, l *xLogger) {
+	for size > 0 && r.n > size {
+		e, _ := r.take()
+		l.putBuf(e.buf)
+		l.ch.drops.Add(1)
+	}
+
+	// a ring that is turned off keeps the pending events till they
+	// are written
+	ev := make([]qev, r.n, max(r.n, size))
+	for i := range ev {
+		ev[i], _ = r.take()
+	}
+	r.ev, r.head, r.n, r.max = ev[:cap(ev)], 0, len(ev), size
+}
+
+// add 'e' to the ring - discarding the oldest event if the ring is full;
+// return false if the ring is off.
+func (r *ring) push(e qev, l *xLogger) bool {
+	r.Lock()
+	if r.max == 0 {
+		r.Unlock()
+		return false
+	}
+
+	if r.n == r.max {
+		old, _ := r.take()
+		l.putBuf(old.buf)
+		l.ch.drops.Add(1)
+	}
+	r.ev[(r.head+r.n)%len(r.ev)] = e
+	r.n++
+	r.Unlock()
+
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// remove and return the oldest event
+func (r *ring) pop() (qev, bool) {
+	r.Lock()
+	defer r.Unlock()
+	return r.take()
+}
+
+// return the number of lines in the ring
+func (l *xLogger) ringLen() int {
+	r := l.ch.ring.Load()
+	if r == nil {
+		return 0
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	return r.n
+}
+
+// remove the oldest event; the caller must hold the lock.
+func (r *ring) take() (qev, bool) {
+	if r.n == 0 {
+		return qev{}, false
+	}
+
+	e := r.ev[r.head]
+	r.ev[r.head] = qev{}
+	r.head = (r.head + 1) % len(r.ev)
+	r.n--
+	return e, true
+}
+
+// return the channel that signals new lines in the ring
+func (l *xLogger) ringWake() <-chan struct{} {
+	if r := l.ch.ring.Load(); r != nil {
+		return r.wake
+	}
+	return nil
+}
+
+// write the lines in the ring. Called only from qrunner.
+func (l *xLogger) drainRing() {
+	r := l.ch.ring.Load()
+	if r == nil {
+		return
+	}
+
+	for {
+		e, ok := r.pop()
+		if !ok {
+			return
+		}
+		l.handle(&e)
+	}
+}
+
+// vim: ft=go:sw=8:ts=8:noexpandtab:tw=98:
@@ -0,0 +1,381 @@
+// rotate.go - log rotation config and dated log file names
+//
+// Copyright 2012, Sudhi Herle <sudhi -at- herle.net>
+// This code is licensed under the same terms as the golang core.
+
+package logger
+
+import (
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RotateInterval is the interval between log rotations
+type RotateInterval int
+
+const (
+	// Daily rotates the logs every day at Hour:Minute:Second (default)
+	Daily RotateInterval = iota
+
+	// Hourly rotates the logs every hour at Minute:Second
+	Hourly
+
+	// Weekly rotates the logs every week on Weekday at
+	// Hour:Minute:Second
+	Weekly
+)
+
+// String returns the name of the rotation interval
+func (i RotateInterval) String() string {
+	switch i {
+	case Daily:
+		return "daily"
+	case Hourly:
+		return "hourly"
+	case Weekly:
+		return "weekly"
+	}
+	return fmt.Sprintf("RotateInterval(%d)", int(i))
+}
+
+// RotateConfig describes how a file backed logger rotates its logs
+type RotateConfig struct {
+	// Interval between rotations; defaults to Daily
+	Interval RotateInterval
+
+	// Day of the week of the Weekly rotation
+	Weekday time.Weekday
+
+	// Time of day (24-hour representation) of the rotation; Hour is
+	// ignored for the Hourly rotation.
+	Hour, Minute, Second int
+
+	// Location is the time zone of the rotation time of day and of the
+	// dates of dated rotated logs; it defaults to UTC. Rotation follows
+	// the wall clock of the zone across DST transitions.
+	Location *time.Location
+
+	// Number of rotated logs to keep; defaults to 7
+	Keep int
+
+	// RetainFor, if non-zero, deletes rotated logs last modified more
+	// than RetainFor ago after each rotation - regardless of Keep. A
+	// rotated log is deleted when it exceeds either bound. Errors while
+	// deleting are reported to the error handler (SetErrorHandler).
+	RetainFor time.Duration
+
+	// Dated names the rotated logs NAME-YYYYMMDD.gz after the rotation
+	// date instead of NAME.0.gz, NAME.1.gz etc; a second rotation on the
+	// same day is named NAME-YYYYMMDD.1.gz and so on.
+	Dated bool
+
+	// Level is the gzip compression level (1-9) of rotated logs; it
+	// defaults to 9 (best compression). Lower levels use less CPU at the
+	// cost of larger rotated logs.
+	Level int
+}
+
+// ConfigureRotation enables log rotation as described by 'c'. Rotated
+// logs are gzip-compressed. Sub-loggers configure the rotation of the
+// logger they were derived from.
+func (l *xLogger) ConfigureRotation(c *RotateConfig) error {
+	// the log file belongs to the root logger
+	l = l.ch.root
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.name) == 0 || (l.flag&lClose) == 0 {
+		return fmt.Errorf("%s: logger is not file backed", l.prefix)
+	}
+
+	hh, mm, ss := c.Hour, c.Minute, c.Second
+	if hh < 0 || hh > 23 || mm < 0 || mm > 59 || ss < 0 || ss > 59 {
+		return fmt.Errorf("invalid rotation config %d:%d.%d", hh, mm, ss)
+	}
+
+	switch c.Interval {
+	case Daily, Hourly:
+	case Weekly:
+		if c.Weekday < time.Sunday || c.Weekday > time.Saturday {
+			return fmt.Errorf("invalid rotation weekday %d", c.Weekday)
+		}
+	default:
+		return fmt.Errorf("invalid rotation interval %d", c.Interval)
+	}
+
+	if c.RetainFor < 0 {
+		return fmt.Errorf("invalid rotation retention %s", c.RetainFor)
+	}
+
+	if c.Level < 0 || c.Level > gzip.BestCompression {
+		return fmt.Errorf("invalid rotation compression level %d", c.Level)
+	}
+
+	rc := *c
+	if rc.Keep <= 0 {
+		rc.Keep = _MAX_LOGFILES
+	}
+	if rc.Level == 0 {
+		rc.Level = gzip.BestCompression
+	}
+
+	if rc.Location == nil {
+		rc.Location = time.UTC
+	}
+
+	l.flag |= lRotate
+	l.rotcfg = rc
+	l.rotnxt = time.Time{}
+
+	// This is the time for next file-rotation
+	x := l.scheduleRotation()
+
+	// If the pre-existing log was last written before the most recent
+	// rotation time, we missed a rotation while the process was down.
+	mtime := l.mtime
+	catchup := !mtime.IsZero() && mtime.Before(rc.prevRotation(x))
+	l.mtime = time.Time{}
+
+	// we can't log while holding the lock
+	l.mu.Unlock()
+	if rc.RetainFor > 0 {
+		l.Info("logger: Enabled %s log-rotation (keep %d logs, at most %s); first rotation at %s",
+			rc.Interval, rc.Keep, rc.RetainFor, x.Format(time.RFC822Z))
+	} else {
+		l.Info("logger: Enabled %s log-rotation (keep %d logs); first rotation at %s",
+			rc.Interval, rc.Keep, x.Format(time.RFC822Z))
+	}
+
+	if catchup {
+		l.Info("logger: log file last written at %s; rotating now", mtime.UTC().Format(time.RFC822Z))
+
+		// wait for it: later lines belong in the new file
+		done := make(chan error, 1)
+		if l.qevent(qev{ty: _QEV_ROTATE, done: done}) {
+			<-done
+		}
+	}
+	l.mu.Lock()
+	return nil
+}
+
+// afterFunc starts the rotation timer; it's a variable so tests can
+// control time.
+var afterFunc = time.AfterFunc
+
+// start the timer for the next rotation and return its time; the caller
+// must hold the lock. The next rotation time is computed afresh from the
+// configured time of day - so that rotation doesn't drift, however late
+// the timer fires - and it is always after the previously scheduled
+// one: a timer that fires early doesn't rotate twice.
+func (l *xLogger) scheduleRotation() time.Time {
+	n := l.now()
+	t := n
+	if t.Before(l.rotnxt) {
+		t = l.rotnxt
+	}
+
+	x := l.rotcfg.nextRotation(t)
+	l.rotnxt = x
+	afterFunc(x.Sub(n), l.qtimer)
+	return x
+}
+
+// return the first rotation time after 'now'. The next day or week is
+// computed from the calendar of the configured zone: a day is 23 or 25
+// hours long across DST transitions.
+func (rc *RotateConfig) nextRotation(now time.Time) time.Time {
+	n := now.In(rc.Location)
+	y, m, d := n.Date()
+
+	switch rc.Interval {
+	case Hourly:
+		// hours are of fixed length; the wall clock repeats or skips
+		// an hour across DST transitions.
+		x := time.Date(y, m, d, n.Hour(), rc.Minute, rc.Second, 0, rc.Location)
+		for !x.After(n) {
+			x = x.Add(time.Hour)
+		}
+		return x
+
+	case Weekly:
+		d += (int(rc.Weekday) - int(n.Weekday()) + 7) % 7
+		x := time.Date(y, m, d, rc.Hour, rc.Minute, rc.Second, 0, rc.Location)
+		if !x.After(n) {
+			x = time.Date(y, m, d+7, rc.Hour, rc.Minute, rc.Second, 0, rc.Location)
+		}
+		return x
+	}
+
+	x := time.Date(y, m, d, rc.Hour, rc.Minute, rc.Second, 0, rc.Location)
+
+	// If we ended up in "yesterday", then set the reminder
+	// for the "next day"
+	if !x.After(n) {
+		x = time.Date(y, m, d+1, rc.Hour, rc.Minute, rc.Second, 0, rc.Location)
+	}
+	return x
+}
+
+// return the rotation time before the rotation time 'x'
+func (rc *RotateConfig) prevRotation(x time.Time) time.Time {
+	x = x.In(rc.Location)
+	y, m, d := x.Date()
+
+	switch rc.Interval {
+	case Hourly:
+		return x.Add(-time.Hour)
+	case Weekly:
+		d -= 7
+	default:
+		d--
+	}
+	return time.Date(y, m, d, rc.Hour, rc.Minute, rc.Second, 0, rc.Location)
+}
+
+// SetRotateHook sets a function that is called after each log rotation
+// completes with the path of the compressed log - or with an error if the
+// rotation failed; a nil fn removes the hook. The hook is shared by all
+// sub-loggers. It is called from the background goroutine that
+// compresses the rotated log: it may log via this logger but it must not
+// block indefinitely - Close() waits for it to return.
+func (l *xLogger) SetRotateHook(fn func(compressedPath string, err error)) {
+	if fn == nil {
+		l.ch.rothook.Store(nil)
+	} else {
+		l.ch.rothook.Store(&fn)
+	}
+}
+
+// call the rotation hook (if any)
+func (l *xLogger) rotated(gz string, err error) {
+	if fn := l.ch.rothook.Load(); fn != nil {
+		(*fn)(gz, err)
+	}
+}
+
+// a dated rotated log
+type datedLog struct {
+	name string
+	date string // YYYYMMDD
+	n    int    // rotation number on 'date'
+}
+
+// return the name of the dated rotated log of 'fn' for a rotation at
+// time 't'; it sorts after all earlier rotations on the same day.
+func datedName(fn string, t time.Time) (string, error) {
+	v, err := datedLogs(fn)
+	if err != nil {
+		return "", err
+	}
+
+	date := t.Format("20060102")
+	n := -1
+	for i := range v {
+		if v[i].date == date && v[i].n > n {
+			n = v[i].n
+		}
+	}
+
+	if n < 0 {
+		return fmt.Sprintf("%s-%s.gz", fn, date), nil
+	}
+	return fmt.Sprintf("%s-%s.%d.gz", fn, date, n+1), nil
+}
+
+// return the dated rotated logs of 'fn' - oldest first
+func datedLogs(fn string) ([]datedLog, error) {
+	names, err := filepath.Glob(fn + "-[0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9]*.gz")
+	if err != nil {
+		return nil, err
+	}
+
+	var v []datedLog
+	for _, nm := range names {
+		s := strings.TrimSuffix(nm[len(fn)+1:], ".gz")
+		d := datedLog{name: nm, date: s[:8]}
+		if len(s) > 8 {
+			n, err := strconv.Atoi(s[9:])
+			if s[8] != '.' || err != nil {
+				continue
+			}
+			d.n = n
+		}
+		v = append(v, d)
+	}
+
+	sort.Slice(v, func(i, j int) bool {
+		if v[i].date == v[j].date {
+			return v[i].n < v[j].n
+		}
+		return v[i].date < v[j].date
+	})
+	return v, nil
+}
+
+// delete the oldest dated rotated logs of 'fn' so that at most 'keep'
+// remain.
+func pruneDated(fn string, keep int) error {
+	v, err := datedLogs(fn)
+	if err != nil {
+		return err
+	}
+
+	for len(v) > keep && len(v) > 0 {
+		if err = os.Remove(v[0].name); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("%s rm: %w", v[0].name, err)
+		}
+		v = v[1:]
+	}
+	return nil
+}
+
+// delete the rotated logs of 'fn' - numbered or dated - that were last
+// modified before 'cutoff'.
+func pruneAge(fn string, cutoff time.Time) error {
+	var names []string
+
+	v, err := filepath.Glob(fn + ".[0-9]*.gz")
+	if err != nil {
+		return err
+	}
+	for _, nm := range v {
+		if _, err := strconv.Atoi(strings.TrimSuffix(nm[len(fn)+1:], ".gz")); err == nil {
+			names = append(names, nm)
+		}
+	}
+
+	dv, err := datedLogs(fn)
+	if err != nil {
+		return err
+	}
+	for i := range dv {
+		names = append(names, dv[i].name)
+	}
+
+	for _, nm := range names {
+		fi, err := os.Stat(nm)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+
+		if fi.Mode().IsRegular() && fi.ModTime().Before(cutoff) {
+			if err = os.Remove(nm); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("%s rm: %w", nm, err)
+			}
+		}
+	}
+	return nil
+}
+
+// vim: ft=go:sw=8:ts=8:noexpandtab:tw=98:
@@ -0,0 +1,884 @@
+package logger
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	re "regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// read and decompress a gzip'd file
+func readGz(fn string) (string, error) {
+	fd, err := os.Open(fn)
+	if err != nil {
+		return "", err
+	}
+	defer fd.Close()
+
+	gz, err := gzip.NewReader(fd)
+	if err != nil {
+		return "", err
+	}
+
+	var b bytes.Buffer
+	if _, err = io.Copy(&b, gz); err != nil {
+		return "", err
+	}
+	return b.String(), gz.Close()
+}
+
+func TestRotate(t *testing.T) {
+	assert := newAsserter(t, "rotate")
+	fn := filepath.Join(t.TempDir(), "app.log")
+
+	ll, err := NewFilelog(fn, LOG_INFO, "", 0)
+	assert(err == nil, "can't create log: %s", err)
+
+	err = ll.Rotate()
+	assert(err != nil, "rotate: expected error when rotation is disabled")
+
+	err = ll.EnableRotation(0, 0, 0, 3)
+	assert(err == nil, "enable rotation: %s", err)
+
+	ll.Info("before rotation 1")
+	ll.Info("before rotation 2")
+
+	err = ll.Rotate()
+	assert(err == nil, "rotate: %s", err)
+
+	ll.Info("after rotation")
+	ll.Close()
+
+	old, err := readGz(fn + ".0.gz")
+	assert(err == nil, "read gz: %s", err)
+	assert(strings.Contains(old, "before rotation 1\n"), "missing line 1:\n%s", old)
+	assert(strings.Contains(old, "before rotation 2\n"), "missing line 2:\n%s", old)
+	assert(!strings.Contains(old, "after rotation"), "unexpected line:\n%s", old)
+
+	cur, err := os.ReadFile(fn)
+	assert(err == nil, "read log: %s", err)
+	assert(strings.Contains(string(cur), "after rotation\n"), "missing current line:\n%s", cur)
+	assert(!strings.Contains(string(cur), "before rotation"), "unexpected line:\n%s", cur)
+
+	xl, err := New(&nullWriter{}, LOG_INFO, "", 0)
+	assert(err == nil, "can't create log: %s", err)
+	defer xl.Close()
+
+	err = xl.(RotatableLogger).Rotate()
+	assert(err != nil, "rotate: expected error for non-file logger")
+}
+
+func TestRotateCatchup(t *testing.T) {
+	assert := newAsserter(t, "rotate-catchup")
+	fn := filepath.Join(t.TempDir(), "app.log")
+
+	err := os.WriteFile(fn, []byte("old log line\n"), 0600)
+	assert(err == nil, "write: %s", err)
+
+	old := time.Now().Add(-48 * time.Hour)
+	err = os.Chtimes(fn, old, old)
+	assert(err == nil, "chtimes: %s", err)
+
+	ll, err := NewFilelogAppend(fn, LOG_INFO, "", 0)
+	assert(err == nil, "can't create log: %s", err)
+
+	err = ll.EnableRotation(0, 0, 0, 3)
+	assert(err == nil, "enable rotation: %s", err)
+	ll.Info("new log line")
+	ll.Close()
+
+	gz, err := readGz(fn + ".0.gz")
+	assert(err == nil, "read gz: %s", err)
+	assert(strings.Contains(gz, "old log line\n"), "missing old line:\n%s", gz)
+
+	cur, err := os.ReadFile(fn)
+	assert(err == nil, "read log: %s", err)
+	assert(strings.Contains(string(cur), "new log line\n"), "missing new line:\n%s", cur)
+
+	// a freshly created file must not be rotated
+	fn = filepath.Join(t.TempDir(), "new.log")
+	ll, err = NewFilelogAppend(fn, LOG_INFO, "", 0)
+	assert(err == nil, "can't create log: %s", err)
+
+	err = ll.EnableRotation(0, 0, 0, 3)
+	assert(err == nil, "enable rotation: %s", err)
+	ll.Close()
+
+	_, err = os.Stat(fn + ".0.gz")
+	assert(os.IsNotExist(err), "fresh file was rotated")
+}
+
+func TestRotateSlowCompress(t *testing.T) {
+	assert := newAsserter(t, "rotate-slow")
+	fn := filepath.Join(t.TempDir(), "app.log")
+
+	started := make(chan bool)
+	release := make(chan bool)
+	gzCopy = func(dst io.Writer, src io.Reader) (int64, error) {
+		close(started)
+		<-release
+		return io.Copy(dst, src)
+	}
+	defer func() {
+		gzCopy = io.Copy
+	}()
+
+	ll, err := NewFilelog(fn, LOG_INFO, "", 0)
+	assert(err == nil, "can't create log: %s", err)
+
+	err = ll.EnableRotation(0, 0, 0, 3)
+	assert(err == nil, "enable rotation: %s", err)
+
+	ll.Info("before rotation")
+	err = ll.Rotate()
+	assert(err == nil, "rotate: %s", err)
+	<-started
+
+	// compression is blocked; logging must continue to the new file
+	for i := 0; i < 10; i++ {
+		ll.Info("during compression %d", i)
+	}
+
+	var cur []byte
+	for i := 0; i < 100; i++ {
+		cur, err = os.ReadFile(fn)
+		assert(err == nil, "read log: %s", err)
+		if strings.Contains(string(cur), "during compression 9\n") {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert(strings.Contains(string(cur), "during compression 9\n"), "writes blocked during compression:\n%s", cur)
+
+	_, err = os.Stat(fn + ".0.gz")
+	assert(os.IsNotExist(err), "compressed file before compression finished")
+
+	close(release)
+	ll.Close()
+
+	old, err := readGz(fn + ".0.gz")
+	assert(err == nil, "read gz: %s", err)
+	assert(strings.Contains(old, "before rotation\n"), "missing line:\n%s", old)
+	assert(!strings.Contains(old, "during compression"), "unexpected line:\n%s", old)
+
+	m, err := filepath.Glob(fn + ".*")
+	assert(err == nil, "glob: %s", err)
+	assert(len(m) == 1, "temp files left behind: %v", m)
+}
+
+func TestRotateDated(t *testing.T) {
+	assert := newAsserter(t, "rotate-dated")
+	fn := filepath.Join(t.TempDir(), "app.log")
+
+	ll, err := NewFilelog(fn, LOG_INFO, "", 0)
+	assert(err == nil, "can't create log: %s", err)
+
+	x := ll.(*xLogger)
+	now := time.Date(2024, 1, 23, 10, 0, 0, 0, time.UTC)
+	x.SetClock(func() time.Time { return now })
+
+	err = ll.ConfigureRotation(&RotateConfig{Keep: 2, Dated: true})
+	assert(err == nil, "configure rotation: %s", err)
+
+	for i := 0; i < 3; i++ {
+		ll.Info("day 1 log %d", i)
+		err = ll.Rotate()
+		assert(err == nil, "rotate: %s", err)
+	}
+
+	now = now.Add(24 * time.Hour)
+	ll.Info("day 2 log")
+	err = ll.Rotate()
+	assert(err == nil, "rotate: %s", err)
+	ll.Close()
+
+	names, err := filepath.Glob(fn + "-*.gz")
+	assert(err == nil, "glob: %s", err)
+	for i := range names {
+		names[i] = filepath.Base(names[i])
+	}
+	exp := []string{"app.log-20240123.2.gz", "app.log-20240124.gz"}
+	assert(strings.Join(names, " ") == strings.Join(exp, " "), "exp %v, saw %v", exp, names)
+
+	gz, err := readGz(fn + "-20240123.2.gz")
+	assert(err == nil, "read gz: %s", err)
+	assert(strings.Contains(gz, "day 1 log 2\n"), "missing line:\n%s", gz)
+
+	gz, err = readGz(fn + "-20240124.gz")
+	assert(err == nil, "read gz: %s", err)
+	assert(strings.Contains(gz, "day 2 log\n"), "missing line:\n%s", gz)
+}
+
+func TestRotateRetainFor(t *testing.T) {
+	assert := newAsserter(t, "rotate-retain")
+	fn := filepath.Join(t.TempDir(), "app.log")
+
+	now := time.Now()
+	old := map[string]time.Duration{
+		".0.gz":          24 * time.Hour,
+		".1.gz":          40 * 24 * time.Hour,
+		"-20200101.gz":   40 * 24 * time.Hour,
+		"-20240101.1.gz": 2 * 24 * time.Hour,
+	}
+	for sfx, age := range old {
+		err := os.WriteFile(fn+sfx, []byte("x"), 0600)
+		assert(err == nil, "write: %s", err)
+
+		mt := now.Add(-age)
+		err = os.Chtimes(fn+sfx, mt, mt)
+		assert(err == nil, "chtimes: %s", err)
+	}
+
+	ll, err := NewFilelog(fn, LOG_INFO, "", 0)
+	assert(err == nil, "can't create log: %s", err)
+
+	err = ll.ConfigureRotation(&RotateConfig{Keep: 10, RetainFor: 30 * 24 * time.Hour})
+	assert(err == nil, "configure rotation: %s", err)
+
+	ll.Info("rotate me")
+	err = ll.Rotate()
+	assert(err == nil, "rotate: %s", err)
+	ll.Close()
+
+	// .0 -> .1 and .1 -> .2 by the rotation; the latter is too old
+	for sfx, want := range map[string]bool{
+		".0.gz":          true,
+		".1.gz":          true,
+		".2.gz":          false,
+		"-20200101.gz":   false,
+		"-20240101.1.gz": true,
+	} {
+		_, err := os.Stat(fn + sfx)
+		assert(want == (err == nil), "%s: exp exist=%v, saw %v", sfx, want, err)
+	}
+
+	err = ll.ConfigureRotation(&RotateConfig{RetainFor: -time.Hour})
+	assert(err != nil, "expected error for negative retention")
+}
+
+func TestRotateLevel(t *testing.T) {
+	assert := newAsserter(t, "rotate-level")
+	fn := filepath.Join(t.TempDir(), "app.log")
+
+	ll, err := NewFilelog(fn, LOG_INFO, "", 0)
+	assert(err == nil, "can't create log: %s", err)
+
+	for _, lvl := range []int{-1, 10} {
+		err = ll.ConfigureRotation(&RotateConfig{Level: lvl})
+		assert(err != nil, "expected error for level %d", lvl)
+	}
+
+	err = ll.ConfigureRotation(&RotateConfig{Level: 1})
+	assert(err == nil, "configure rotation: %s", err)
+
+	ll.Info("fast compression")
+	err = ll.Rotate()
+	assert(err == nil, "rotate: %s", err)
+	ll.Close()
+
+	gz, err := readGz(fn + ".0.gz")
+	assert(err == nil, "read gz: %s", err)
+	assert(strings.Contains(gz, "fast compression\n"), "missing line:\n%s", gz)
+}
+
+func TestRotateHook(t *testing.T) {
+	assert := newAsserter(t, "rotate-hook")
+	fn := filepath.Join(t.TempDir(), "app.log")
+
+	ll, err := NewFilelog(fn, LOG_INFO, "", 0)
+	assert(err == nil, "can't create log: %s", err)
+
+	var gotPath string
+	var gotErr error
+	var calls int
+	ll.(*xLogger).SetRotateHook(func(p string, err error) {
+		gotPath, gotErr = p, err
+		calls++
+	})
+
+	err = ll.EnableRotation(0, 0, 0, 3)
+	assert(err == nil, "enable rotation: %s", err)
+
+	ll.Info("rotate me")
+	err = ll.Rotate()
+	assert(err == nil, "rotate: %s", err)
+	ll.Close()
+
+	// Close waits for the compression and thus the hook
+	assert(calls == 1, "exp 1 hook call, saw %d", calls)
+	assert(gotErr == nil, "hook error: %s", gotErr)
+	assert(gotPath == fn+".0.gz", "hook path: exp %s, saw %s", fn+".0.gz", gotPath)
+
+	_, err = os.Stat(gotPath)
+	assert(err == nil, "compressed log: %s", err)
+}
+
+func TestRotateCreateFail(t *testing.T) {
+	assert := newAsserter(t, "rotate-createfail")
+	fn := filepath.Join(t.TempDir(), "app.log")
+
+	// fail creating the compressed temp file
+	errPerm := errors.New("permission denied")
+	openFile = func(name string, flag int, perm os.FileMode) (*os.File, error) {
+		if name != fn {
+			return nil, errPerm
+		}
+		return os.OpenFile(name, flag, perm)
+	}
+	defer func() { openFile = os.OpenFile }()
+
+	ll, err := NewFilelog(fn, LOG_INFO, "50%", 0)
+	assert(err == nil, "can't create log: %s", err)
+
+	var herr error
+	ll.(*xLogger).SetRotateHook(func(p string, err error) {
+		herr = err
+	})
+
+	err = ll.EnableRotation(0, 0, 0, 3)
+	assert(err == nil, "enable rotation: %s", err)
+
+	ll.Info("rotate me")
+	err = ll.Rotate()
+	assert(err == nil, "rotate: %s", err)
+	ll.Close()
+
+	assert(herr != nil, "expected rotation error")
+
+	s := herr.Error()
+	rx := re.MustCompile(`^logger \[50%\] : logrotate: ` + re.QuoteMeta(fn) + `\.[0-9a-f]+ create: permission denied$`)
+	assert(rx.MatchString(s), "bad error: %s", s)
+	assert(!strings.Contains(s, "%!"), "format garbage: %s", s)
+}
+
+func TestRotateCompressFail(t *testing.T) {
+	assert := newAsserter(t, "rotate-compressfail")
+	fn := filepath.Join(t.TempDir(), "app.log")
+
+	ll, err := NewFilelog(fn, LOG_INFO, "", 0)
+	assert(err == nil, "can't create log: %s", err)
+
+	done := make(chan error, 2)
+	ll.(*xLogger).SetRotateHook(func(p string, err error) {
+		done <- err
+	})
+
+	err = ll.EnableRotation(0, 0, 0, 3)
+	assert(err == nil, "enable rotation: %s", err)
+
+	ll.Info("first rotation")
+	err = ll.Rotate()
+	assert(err == nil, "rotate: %s", err)
+	err = <-done
+	assert(err == nil, "first rotation: %s", err)
+
+	// fail the compression of the second rotation
+	gzCopy = func(dst io.Writer, src io.Reader) (int64, error) {
+		return 0, errors.New("disk full")
+	}
+	defer func() {
+		gzCopy = io.Copy
+	}()
+
+	ll.Info("second rotation")
+	err = ll.Rotate()
+	assert(err == nil, "rotate: %s", err)
+	ll.Close()
+
+	err = <-done
+	assert(err != nil, "expected rotation error")
+
+	// the older logs are untouched
+	old, err := readGz(fn + ".0.gz")
+	assert(err == nil, "read gz: %s", err)
+	assert(strings.Contains(old, "first rotation\n"), "missing line:\n%s", old)
+	_, err = os.Stat(fn + ".1.gz")
+	assert(os.IsNotExist(err), "older logs were rotated")
+
+	// the lines of the failed rotation survive in the rotated out file
+	names, err := filepath.Glob(fn + ".*")
+	assert(err == nil, "glob: %s", err)
+
+	var saved []string
+	for _, nm := range names {
+		if strings.HasSuffix(nm, ".gz") {
+			continue
+		}
+		b, err := os.ReadFile(nm)
+		assert(err == nil, "read %s: %s", nm, err)
+		if strings.Contains(string(b), "second rotation\n") {
+			saved = append(saved, nm)
+		}
+	}
+	assert(len(saved) == 1, "lost the rotated out log: %v", names)
+}
+
+// rotate while concurrently logging and changing flags; meant to be run
+// with -race.
+func TestRotateConcurrent(t *testing.T) {
+	assert := newAsserter(t, "rotate-concurrent")
+	fn := filepath.Join(t.TempDir(), "app.log")
+
+	ll, err := NewFilelog(fn, LOG_INFO, "", 0)
+	assert(err == nil, "can't create log: %s", err)
+
+	err = ll.EnableRotation(0, 0, 0, 3)
+	assert(err == nil, "enable rotation: %s", err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sub := ll.New("sub", LOG_INFO)
+			for j := 0; j < 200; j++ {
+				sub.Info("go-%d: line %d", i, j)
+				_ = ll.StackTrace(1)
+			}
+		}(i)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		x := ll.(*xLogger)
+		for j := 0; j < 50; j++ {
+			x.SetFlags(Ldate | Ltime | Lmicroseconds)
+			x.SetFlags(Ldate | Ltime)
+		}
+	}()
+
+	for i := 0; i < 3; i++ {
+		err = ll.Rotate()
+		assert(err == nil, "rotate %d: %s", i, err)
+	}
+
+	wg.Wait()
+	err = ll.Close()
+	assert(err == nil, "close: %s", err)
+
+	_, err = readGz(fn + ".0.gz")
+	assert(err == nil, "read gz: %s", err)
+}
+
+func TestRotateReltime(t *testing.T) {
+	assert := newAsserter(t, "rotate-reltime")
+	fn := filepath.Join(t.TempDir(), "app.log")
+
+	ll, err := NewFilelog(fn, LOG_INFO, "", Lreltime)
+	assert(err == nil, "can't create log: %s", err)
+
+	err = ll.EnableRotation(0, 0, 0, 3)
+	assert(err == nil, "enable rotation: %s", err)
+
+	// lines formatted before the rotation but written after it
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					ll.Info("busy")
+				}
+			}
+		}()
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	err = ll.Rotate()
+	assert(err == nil, "rotate: %s", err)
+	close(stop)
+	wg.Wait()
+	ll.Close()
+
+	cur, err := os.ReadFile(fn)
+	assert(err == nil, "read log: %s", err)
+
+	lines := strings.Split(string(cur), "\n")
+	assert(len(lines) > 2, "too few lines:\n%s", cur)
+
+	abs := re.MustCompile(`^<2>:\d{4}/\d\d/\d\d \d\d:\d\d:\d\d\.\d+ `)
+	assert(abs.MatchString(lines[0]), "first line not absolute: %q", lines[0])
+	for _, s := range lines[1 : len(lines)-1] {
+		assert(strings.HasPrefix(s, "<2>:+"), "later line not relative: %q", s)
+	}
+}
+
+// counts the bytes written to the wrapped writer
+type countWriter struct {
+	w io.Writer
+	n *atomic.Int64
+}
+
+func (c *countWriter) Write(b []byte) (int, error) {
+	c.n.Add(int64(len(b)))
+	return c.w.Write(b)
+}
+
+func TestRotateMiddleware(t *testing.T) {
+	assert := newAsserter(t, "rotate-middleware")
+	fn := filepath.Join(t.TempDir(), "app.log")
+
+	ll, err := NewFilelog(fn, LOG_INFO, "", 0)
+	assert(err == nil, "can't create log: %s", err)
+
+	err = ll.EnableRotation(0, 0, 0, 3)
+	assert(err == nil, "enable rotation: %s", err)
+
+	var n atomic.Int64
+	var wraps atomic.Int32
+	ll.(*xLogger).AddWriteMiddleware(func(w io.Writer) io.Writer {
+		_, ok := w.(*os.File)
+		assert(ok, "middleware doesn't wrap the file: %T", w)
+		wraps.Add(1)
+		return &countWriter{w, &n}
+	})
+
+	ll.Info("before rotation")
+	err = ll.Rotate()
+	assert(err == nil, "rotate: %s", err)
+	ll.Info("after rotation")
+	ll.Close()
+
+	assert(wraps.Load() == 2, "exp 2 wraps, saw %d", wraps.Load())
+
+	old, err := readGz(fn + ".0.gz")
+	assert(err == nil, "read gz: %s", err)
+	assert(strings.Contains(old, "before rotation\n"), "missing line:\n%s", old)
+
+	cur, err := os.ReadFile(fn)
+	assert(err == nil, "read log: %s", err)
+	assert(strings.Contains(string(cur), "after rotation\n"), "missing line:\n%s", cur)
+
+	// everything but the startup line went through the middleware
+	i := strings.Index(old, "\n") + 1
+	exp := int64(len(old) - i + len(cur))
+	assert(n.Load() == exp, "exp %d bytes, saw %d", exp, n.Load())
+}
+
+func TestRotateLocation(t *testing.T) {
+	assert := newAsserter(t, "rotate-location")
+
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("no tz database: %s", err)
+	}
+
+	rc := &RotateConfig{Hour: 0, Minute: 30, Location: loc}
+
+	// DST starts on 2024-03-10 and ends on 2024-11-03 at 02:00
+	tests := []struct {
+		now  time.Time
+		exp  time.Time
+		span time.Duration // duration to the rotation after 'exp'
+	}{
+		{time.Date(2024, 3, 9, 12, 0, 0, 0, loc), time.Date(2024, 3, 10, 0, 30, 0, 0, loc), 23 * time.Hour},
+		{time.Date(2024, 11, 2, 12, 0, 0, 0, loc), time.Date(2024, 11, 3, 0, 30, 0, 0, loc), 25 * time.Hour},
+		{time.Date(2024, 6, 1, 0, 10, 0, 0, loc), time.Date(2024, 6, 1, 0, 30, 0, 0, loc), 24 * time.Hour},
+
+		// local midnight is 04:00 UTC in the summer
+		{time.Date(2024, 6, 1, 4, 0, 0, 0, time.UTC), time.Date(2024, 6, 1, 0, 30, 0, 0, loc), 24 * time.Hour},
+	}
+
+	for i, tc := range tests {
+		x := rc.nextRotation(tc.now)
+		assert(x.Equal(tc.exp), "%d: exp %s, saw %s", i, tc.exp, x)
+		assert(x.Hour() == 0 && x.Minute() == 30, "%d: wrong wall clock %s", i, x)
+
+		y := rc.nextRotation(x)
+		assert(y.Sub(x) == tc.span, "%d: exp %s to the next rotation, saw %s", i, tc.span, y.Sub(x))
+		assert(y.Hour() == 0 && y.Minute() == 30, "%d: wrong wall clock %s", i, y)
+		assert(rc.prevRotation(y).Equal(x), "%d: prev rotation of %s: %s", i, y, rc.prevRotation(y))
+	}
+
+	fn := filepath.Join(t.TempDir(), "app.log")
+	ll, err := NewFilelog(fn, LOG_INFO, "", 0)
+	assert(err == nil, "can't create log: %s", err)
+	defer ll.Close()
+
+	err = ll.ConfigureRotation(&RotateConfig{Hour: 3, Location: loc})
+	assert(err == nil, "configure rotation: %s", err)
+
+	x := ll.(*xLogger)
+	x.mu.Lock()
+	zone := x.rotcfg.Location
+	x.mu.Unlock()
+	assert(zone == loc, "location not kept: %s", zone)
+
+	err = ll.EnableRotation(3, 0, 0, 2)
+	assert(err == nil, "enable rotation: %s", err)
+
+	x.mu.Lock()
+	zone = x.rotcfg.Location
+	x.mu.Unlock()
+	assert(zone == time.UTC, "exp UTC, saw %s", zone)
+}
+
+func TestRotateSchedule(t *testing.T) {
+	assert := newAsserter(t, "rotate-schedule")
+
+	var delays []time.Duration
+	afterFunc = func(d time.Duration, fn func()) *time.Timer {
+		delays = append(delays, d)
+		return nil
+	}
+	defer func() {
+		afterFunc = time.AfterFunc
+	}()
+
+	fn := filepath.Join(t.TempDir(), "app.log")
+	ll, err := NewFilelog(fn, LOG_INFO, "", 0)
+	assert(err == nil, "can't create log: %s", err)
+	defer ll.Close()
+
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	x := ll.(*xLogger)
+	x.SetClock(func() time.Time { return now })
+
+	err = ll.EnableRotation(1, 2, 3, 3)
+	assert(err == nil, "enable rotation: %s", err)
+
+	// the timer fires late, early and very late
+	exp := time.Date(2024, 1, 2, 1, 2, 3, 0, time.UTC)
+	steps := []time.Duration{
+		1700 * time.Millisecond,
+		-5 * time.Millisecond,
+		3 * time.Hour,
+		23*time.Hour + 59*time.Minute,
+	}
+
+	for i, d := range steps {
+		assert(len(delays) == i+1, "%d: exp %d timers, saw %d", i, i+1, len(delays))
+		assert(now.Add(delays[i]).Equal(exp), "%d: exp rotation at %s, saw %s", i, exp, now.Add(delays[i]))
+
+		now = exp.Add(d)
+		x.mu.Lock()
+		next := x.scheduleRotation()
+		x.mu.Unlock()
+
+		exp = exp.AddDate(0, 0, 1)
+		assert(next.Equal(exp), "%d: exp next rotation at %s, saw %s", i, exp, next)
+	}
+	assert(now.Add(delays[len(steps)]).Equal(exp), "exp rotation at %s, saw %s", exp, now.Add(delays[len(steps)]))
+}
+
+func TestRotateInterval(t *testing.T) {
+	assert := newAsserter(t, "rotate-interval")
+
+	var delays []time.Duration
+	afterFunc = func(d time.Duration, fn func()) *time.Timer {
+		delays = append(delays, d)
+		return nil
+	}
+	defer func() {
+		afterFunc = time.AfterFunc
+	}()
+
+	// 2024-01-03 is a Wednesday
+	now := time.Date(2024, 1, 3, 10, 20, 0, 0, time.UTC)
+
+	tests := []struct {
+		rc   RotateConfig
+		exp  []time.Time // successive rotations
+		then []time.Time // time of each reschedule
+	}{
+		{
+			RotateConfig{Interval: Hourly, Minute: 15, Second: 30},
+			[]time.Time{
+				time.Date(2024, 1, 3, 11, 15, 30, 0, time.UTC),
+				time.Date(2024, 1, 3, 12, 15, 30, 0, time.UTC),
+				time.Date(2024, 1, 3, 15, 15, 30, 0, time.UTC),
+				time.Date(2024, 1, 4, 0, 15, 30, 0, time.UTC),
+			},
+			[]time.Time{
+				time.Date(2024, 1, 3, 11, 15, 31, 0, time.UTC),
+				time.Date(2024, 1, 3, 14, 50, 0, 0, time.UTC),
+				time.Date(2024, 1, 3, 23, 59, 59, 0, time.UTC),
+			},
+		},
+		{
+			RotateConfig{Interval: Hourly, Minute: 30},
+			[]time.Time{
+				time.Date(2024, 1, 3, 10, 30, 0, 0, time.UTC),
+				time.Date(2024, 1, 3, 11, 30, 0, 0, time.UTC),
+			},
+			[]time.Time{
+				time.Date(2024, 1, 3, 10, 29, 59, 0, time.UTC),
+			},
+		},
+		{
+			RotateConfig{Interval: Weekly, Weekday: time.Sunday, Hour: 2},
+			[]time.Time{
+				time.Date(2024, 1, 7, 2, 0, 0, 0, time.UTC),
+				time.Date(2024, 1, 14, 2, 0, 0, 0, time.UTC),
+				time.Date(2024, 1, 28, 2, 0, 0, 0, time.UTC),
+			},
+			[]time.Time{
+				time.Date(2024, 1, 7, 2, 0, 4, 0, time.UTC),
+				time.Date(2024, 1, 21, 3, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			// same weekday, later in the day
+			RotateConfig{Interval: Weekly, Weekday: time.Wednesday, Hour: 23, Minute: 59},
+			[]time.Time{
+				time.Date(2024, 1, 3, 23, 59, 0, 0, time.UTC),
+				time.Date(2024, 1, 10, 23, 59, 0, 0, time.UTC),
+			},
+			[]time.Time{
+				time.Date(2024, 1, 3, 23, 59, 0, 0, time.UTC),
+			},
+		},
+		{
+			// same weekday, earlier in the day
+			RotateConfig{Interval: Weekly, Weekday: time.Wednesday, Hour: 1},
+			[]time.Time{
+				time.Date(2024, 1, 10, 1, 0, 0, 0, time.UTC),
+			},
+			nil,
+		},
+	}
+
+	for i, tc := range tests {
+		fn := filepath.Join(t.TempDir(), "app.log")
+		ll, err := NewFilelog(fn, LOG_INFO, "", 0)
+		assert(err == nil, "can't create log: %s", err)
+
+		clock := now
+		x := ll.(*xLogger)
+		x.SetClock(func() time.Time { return clock })
+
+		delays = delays[:0]
+		err = ll.ConfigureRotation(&tc.rc)
+		assert(err == nil, "%d: configure rotation: %s", i, err)
+		assert(len(delays) == 1, "%d: exp one timer, saw %d", i, len(delays))
+		assert(clock.Add(delays[0]).Equal(tc.exp[0]), "%d: exp first rotation at %s, saw %s",
+			i, tc.exp[0], clock.Add(delays[0]))
+
+		for j, n := range tc.then {
+			clock = n
+			x.mu.Lock()
+			next := x.scheduleRotation()
+			x.mu.Unlock()
+
+			exp := tc.exp[j+1]
+			assert(next.Equal(exp), "%d.%d: exp rotation at %s, saw %s", i, j, exp, next)
+			assert(clock.Add(delays[j+1]).Equal(exp), "%d.%d: exp timer at %s, saw %s",
+				i, j, exp, clock.Add(delays[j+1]))
+		}
+
+		rc := x.rotcfg
+		for _, y := range tc.exp {
+			p := rc.prevRotation(y)
+			assert(rc.nextRotation(p).Equal(y), "%d: prev rotation of %s: %s", i, y, p)
+		}
+		ll.Close()
+	}
+
+	fn := filepath.Join(t.TempDir(), "app.log")
+	ll, err := NewFilelog(fn, LOG_INFO, "", 0)
+	assert(err == nil, "can't create log: %s", err)
+	defer ll.Close()
+
+	err = ll.RotateWeekly(time.Weekday(7), 0, 0, 0, 3)
+	assert(err != nil, "accepted an invalid weekday")
+	err = ll.ConfigureRotation(&RotateConfig{Interval: RotateInterval(9)})
+	assert(err != nil, "accepted an invalid interval")
+	err = ll.RotateHourly(60, 0, 3)
+	assert(err != nil, "accepted an invalid minute")
+
+	err = ll.RotateHourly(5, 0, 3)
+	assert(err == nil, "rotate hourly: %s", err)
+	err = ll.RotateWeekly(time.Friday, 4, 0, 0, 3)
+	assert(err == nil, "rotate weekly: %s", err)
+}
+
+func TestCloneRotate(t *testing.T) {
+	assert := newAsserter(t, "clone-rotate")
+	fn := filepath.Join(t.TempDir(), "app.log")
+
+	ll, err := NewFilelog(fn, LOG_INFO, "", 0)
+	assert(err == nil, "can't create log: %s", err)
+
+	var errs []error
+	var mu sync.Mutex
+	ll.(*xLogger).SetErrorHandler(func(err error) {
+		mu.Lock()
+		errs = append(errs, err)
+		mu.Unlock()
+	})
+
+	err = ll.EnableRotation(0, 0, 0, 3)
+	assert(err == nil, "enable rotation: %s", err)
+
+	cl := ll.Clone()
+	cl.Info("clone before rotation")
+	assert(cl.Flush() == nil, "clone flush failed")
+
+	err = ll.Rotate()
+	assert(err == nil, "rotate: %s", err)
+
+	cl.Info("clone after rotation")
+	ll.Close()
+	cl.Info("clone after close")
+	assert(cl.Close() == nil, "clone close failed")
+
+	mu.Lock()
+	assert(len(errs) == 0, "write errors: %v", errs)
+	mu.Unlock()
+
+	old, err := readGz(fn + ".0.gz")
+	assert(err == nil, "read gz: %s", err)
+	assert(strings.Contains(old, "clone before rotation\n"), "missing line:\n%s", old)
+
+	cur, err := os.ReadFile(fn)
+	assert(err == nil, "read log: %s", err)
+	for _, m := range []string{"clone after rotation\n", "clone after close\n"} {
+		assert(strings.Contains(string(cur), m), "missing %q:\n%s", m, cur)
+	}
+	assert(!strings.Contains(string(cur), "before rotation"), "unexpected line:\n%s", cur)
+}
+
+func TestRotateSyncSublogger(t *testing.T) {
+	assert := newAsserter(t, "rotate-sync-sub")
+	fn := filepath.Join(t.TempDir(), "app.log")
+
+	ll, err := NewFilelogWithOptions(fn, LOG_INFO, "", 0, &Options{Synchronous: true})
+	assert(err == nil, "can't create log: %s", err)
+
+	var errs []error
+	ll.(*xLogger).SetErrorHandler(func(err error) {
+		errs = append(errs, err)
+	})
+
+	err = ll.EnableRotation(0, 0, 0, 3)
+	assert(err == nil, "enable rotation: %s", err)
+
+	sub := ll.New("sub", 0)
+	sub.Info("before rotation")
+
+	err = ll.Rotate()
+	assert(err == nil, "rotate: %s", err)
+
+	sub.Info("after rotation")
+	ll.Close()
+	assert(len(errs) == 0, "write errors: %v", errs)
+
+	cur, err := os.ReadFile(fn)
+	assert(err == nil, "read log: %s", err)
+	assert(strings.Contains(string(cur), "[sub] after rotation\n"), "missing line:\n%s", cur)
+	assert(!strings.Contains(string(cur), "before rotation"), "unexpected line:\n%s", cur)
+}
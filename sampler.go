@@ -0,0 +1,82 @@
+// sampler.go - sampling of repeated log messages
+//
+// Copyright 2012, Sudhi Herle <sudhi -at- herle.net>
+// This code is licensed under the same terms as the golang core.
+
+package logger
+
+import (
+	"sync"
+	"time"
+)
+
+// interval after which the sampler forgets the messages it has seen
+const _SAMPLE_INTERVAL = time.Second
+
+// a message seen by the sampler
+type sampleKey struct {
+	prio Priority
+	key  string
+}
+
+// sampler logs the first few occurrences of a message in each interval
+// and every Mth one thereafter
+type sampler struct {
+	sync.Mutex
+	first      int
+	thereafter int
+
+	start time.Time         // current interval start
+	seen  map[sampleKey]int // occurrences in the current interval
+}
+
+// SetSampler samples repeated log messages: in each one second interval,
+// the first 'first' messages with the same priority and format string
+// are logged and after that, one in every 'thereafter' messages. A
+// 'thereafter' <= 0 drops every message after the first 'first'. A
+// value of first <= 0 removes the sampler. Messages logged via the Fn
+// methods (e.g., DebugFn) are not sampled.
+//
+// Sub-loggers created after this call share the sampler with this logger;
+// they can set their own sampler independently.
+func (l *xLogger) SetSampler(first, thereafter int) {
+	if first <= 0 {
+		l.smp.Store(nil)
+		return
+	}
+
+	l.smp.Store(&sampler{
+		first:      first,
+		thereafter: thereafter,
+		seen:       make(map[sampleKey]int),
+	})
+}
+
+// return true if the sampler allows the message 'key' to be logged now
+func (l *xLogger) sample(prio Priority, key string) bool {
+	s := l.smp.Load()
+	if s == nil || len(key) == 0 {
+		return true
+	}
+
+	now := l.clock()
+
+	s.Lock()
+	defer s.Unlock()
+
+	if now.Sub(s.start) >= _SAMPLE_INTERVAL {
+		s.start = now
+		clear(s.seen)
+	}
+
+	k := sampleKey{prio, key}
+	n := s.seen[k] + 1
+	s.seen[k] = n
+
+	if n <= s.first {
+		return true
+	}
+	return s.thereafter > 0 && (n-s.first)%s.thereafter == 0
+}
+
+// vim: ft=go:sw=8:ts=8:noexpandtab:tw=98:
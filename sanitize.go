@@ -0,0 +1,49 @@
+// sanitize.go - escape control characters in log messages
+//
+// Copyright 2012, Sudhi Herle <sudhi -at- herle.net>
+// This code is licensed under the same terms as the golang core.
+
+package logger
+
+import (
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+)
+
+// SetSanitize enables escaping of control characters - e.g., ANSI escape
+// sequences - and invalid UTF-8 in log messages: each is written as a Go
+// style escape (e.g., "\x1b") so that it can't corrupt the log file or
+// the terminal of whoever reads it. Tabs and newlines are kept as is.
+// Messages sent to a terminal are never sanitized. Sanitizing is off by
+// default. Sub-loggers created after this call inherit the setting.
+func (l *xLogger) SetSanitize(on bool) {
+	l.mu.Lock()
+	l.sanitize = on && !isTerminal(l.out)
+	l.mu.Unlock()
+}
+
+// append 's' to 'b' with control characters and invalid UTF-8 escaped
+func appendSanitized(b, s []byte) []byte {
+	for len(s) > 0 {
+		r, n := utf8.DecodeRune(s)
+		switch {
+		case r == '\t' || r == '\n':
+			b = append(b, byte(r))
+		case r == utf8.RuneError && n == 1:
+			b = fmt.Appendf(b, `\x%02x`, s[0])
+		case unicode.IsControl(r):
+			if r < utf8.RuneSelf {
+				b = fmt.Appendf(b, `\x%02x`, r)
+			} else {
+				b = fmt.Appendf(b, `\u%04x`, r)
+			}
+		default:
+			b = append(b, s[:n]...)
+		}
+		s = s[n:]
+	}
+	return b
+}
+
+// vim: ft=go:sw=8:ts=8:noexpandtab:tw=98:
@@ -7,6 +7,7 @@
 package logger
 
 import (
+	"io"
 	stdlog "log"
 )
 
@@ -18,7 +19,7 @@ func fl2std(flag int) int {
 	if 0 != (flag & Ltime) {
 		fl |= stdlog.Ltime
 	}
-	if 0 != (flag & Lmicroseconds) {
+	if 0 != (flag & (Lmicroseconds | Lnanoseconds)) {
 		fl |= stdlog.Lmicroseconds
 	}
 	if 0 != (flag & Lfileloc) {
@@ -39,7 +40,7 @@ func (l *xLogger) StdLogger() *stdlog.Logger {
 	if g = l.stdlogger.Load(); g == nil {
 		// here first argument 'l' is the io.Writer; we provide its
 		// interface implementation below.
-		g = stdlog.New(l, l.prefix, fl2std(l.flag))
+		g = stdlog.New(l, l.Prefix(), fl2std(l.Flags()))
 
 		if !l.stdlogger.CompareAndSwap(nil, g) {
 			g = l.stdlogger.Load()
@@ -50,7 +51,59 @@ func (l *xLogger) StdLogger() *stdlog.Logger {
 
 // We only provide an ioWriter implementation for stdlogger
 func (l *xLogger) Write(b []byte) (int, error) {
-	l.qwrite(b)
+	l.mu.Lock()
+	eol, fmtr := l.eol, l.fmtr
+	l.mu.Unlock()
+
+	// the caller owns 'b' and qrunner returns the queued buffer to the
+	// pool; queue a copy. Formatters apply the line ending themselves.
+	x := append(l.getBuf(), b...)
+	if fmtr == nil {
+		x = setEOL(x, eol)
+	}
+	l.qwrite(x, 0)
+	return len(b), nil
+}
+
+// rawWriter funnels raw writes into the log queue
+type rawWriter struct {
+	l *xLogger
+}
+
+// Writer returns an io.Writer that writes to the same destination as the
+// logger; the bytes are written as is and in order with the log lines.
+func (l *xLogger) Writer() io.Writer {
+	return &rawWriter{l}
+}
+
+func (w *rawWriter) Write(b []byte) (int, error) {
+	// the caller owns 'b'; queue a copy
+	w.l.qwrite(append(w.l.getBuf(), b...), 0)
+	return len(b), nil
+}
+
+// levelWriter logs each write as a message at a fixed priority
+type levelWriter struct {
+	l    *xLogger
+	prio Priority
+}
+
+// LevelWriter returns an io.Writer that logs each write as a message at
+// priority 'p' - e.g., for libraries that take an io.Writer or a
+// *log.Logger:
+//
+//	srv := &http.Server{ErrorLog: log.New(l.LevelWriter(LOG_ERR), "", 0)}
+//
+// Writes are dropped if the logger doesn't log at 'p' (see Loggable).
+func (l *xLogger) LevelWriter(p Priority) io.Writer {
+	return &levelWriter{l, p}
+}
+
+func (w *levelWriter) Write(b []byte) (int, error) {
+	if w.l.enabled(w.prio, "%s") {
+		// the caller owns 'b'; format a copy
+		w.l.Output(0, w.prio, "%s", string(b))
+	}
 	return len(b), nil
 }
 
@@ -64,4 +117,12 @@ func (e *emptyLogger) Write(b []byte) (int, error) {
 	return len(b), nil
 }
 
+func (e *emptyLogger) Writer() io.Writer {
+	return io.Discard
+}
+
+func (e *emptyLogger) LevelWriter(p Priority) io.Writer {
+	return io.Discard
+}
+
 // vim: ft=go:sw=8:ts=8:noexpandtab:tw=98:
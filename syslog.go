@@ -0,0 +1,194 @@
+// syslog.go - syslog output with configurable facilities
+//
+// Copyright 2012, Sudhi Herle <sudhi -at- herle.net>
+// This code is licensed under the same terms as the golang core.
+
+package logger
+
+import (
+	"fmt"
+	"log/syslog"
+	"os"
+	"path"
+)
+
+// SyslogConfig describes the syslog facilities used by a logger created
+// via NewSyslogWithConfig().
+type SyslogConfig struct {
+	// Tag identifies the program in each message; it defaults to the
+	// program name.
+	Tag string
+
+	// Facility is the facility of every message; it defaults to
+	// syslog.LOG_DAEMON.
+	Facility syslog.Priority
+
+	// Facilities overrides the facility of the messages at the given
+	// levels - e.g., to send LOG_ERR and above to syslog.LOG_AUTHPRIV.
+	Facilities map[Priority]syslog.Priority
+}
+
+// NewSyslogWithConfig is like NewSyslog but sends each message with the
+// syslog severity of its priority and the facility chosen by 'c'; a nil
+// 'c' selects the defaults. Lines written via StdLogger() or Writer() are
+// sent at LOG_NOTICE in the default facility.
+func NewSyslogWithConfig(c *SyslogConfig, prio Priority, prefix string, flag int) (Logger, error) {
+	var cfg SyslogConfig
+	if c != nil {
+		cfg = *c
+	}
+	if len(cfg.Tag) == 0 {
+		cfg.Tag = path.Base(os.Args[0])
+	}
+	if cfg.Facility == 0 {
+		cfg.Facility = syslog.LOG_DAEMON
+	}
+
+	w := &syslogOut{
+		tag:  cfg.Tag,
+		fac:  cfg.Facility & _SYSLOG_FACMASK,
+		facs: make(map[Priority]syslog.Priority),
+		ws:   make(map[syslog.Priority]*syslog.Writer),
+	}
+	for p, f := range cfg.Facilities {
+		w.facs[p] = f & _SYSLOG_FACMASK
+	}
+
+	// connect up front so the caller learns of a missing syslogd
+	if _, err := w.writer(w.fac); err != nil {
+		return nil, fmt.Errorf("%s: syslog: %w", cfg.Tag, err)
+	}
+
+	return newLogger(w, prio, prefix, defaultFlag(flag)|lSyslog|lClose, nil), nil
+}
+
+// mask of the facility bits of a syslog priority
+const _SYSLOG_FACMASK = 0xf8
+
+// syslogOut sends each log line to the syslog writer of its facility;
+// only accessed by the writer.
+type syslogOut struct {
+	tag  string
+	fac  syslog.Priority              // default facility
+	facs map[Priority]syslog.Priority // facility per level
+	ws   map[syslog.Priority]*syslog.Writer
+
+	// priority of the line being written; set by the writer before each
+	// write.
+	cur Priority
+}
+
+// return the syslog writer for facility 'fac'
+func (s *syslogOut) writer(fac syslog.Priority) (*syslog.Writer, error) {
+	if w, ok := s.ws[fac]; ok {
+		return w, nil
+	}
+
+	w, err := syslogNew(fac|syslog.LOG_NOTICE, s.tag)
+	if err != nil {
+		return nil, err
+	}
+	s.ws[fac] = w
+	return w, nil
+}
+
+// Write sends 'b' with the severity and facility of the current priority
+func (s *syslogOut) Write(b []byte) (int, error) {
+	p := s.cur
+	s.cur = 0
+
+	fac, ok := s.facs[p]
+	if !ok {
+		fac = s.fac
+	}
+
+	w, err := s.writer(fac)
+	if err != nil {
+		return 0, err
+	}
+
+	m := string(b)
+	switch {
+	case p <= LOG_NONE || p >= logMax:
+		_, err = w.Write(b)
+	case p == LOG_DEBUG:
+		err = w.Debug(m)
+	case p == LOG_INFO:
+		err = w.Info(m)
+	case p == LOG_NOTICE:
+		err = w.Notice(m)
+	case p == LOG_WARN:
+		err = w.Warning(m)
+	case p == LOG_ERR:
+		err = w.Err(m)
+	case p == LOG_CRIT:
+		err = w.Crit(m)
+	case p == LOG_ALERT:
+		err = w.Alert(m)
+	default:
+		err = w.Emerg(m)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Close closes the connections to syslog
+func (s *syslogOut) Close() error {
+	var err error
+	for _, w := range s.ws {
+		if xerr := w.Close(); xerr != nil && err == nil {
+			err = xerr
+		}
+	}
+	return err
+}
+
+// syslog PRI marker of text lines
+type syslogPRI struct {
+	fac int
+	sev [logMax]int
+}
+
+// SetSyslogPRI makes the "<N>" marker of text lines the syslog PRI of
+// the line - facility*8 + severity - instead of the numeric priority; the
+// output can then be consumed by syslog parsers. 'facility' is one of the
+// syslog facilities (e.g., syslog.LOG_LOCAL0); 'sev' maps priorities to
+// syslog severities (0-7); priorities missing from 'sev' - or a nil
+// 'sev' - use the standard severities (LOG_ERR is 3). A negative facility
+// restores the numeric priority. Sub-loggers created after this call
+// inherit the marker.
+func (l *xLogger) SetSyslogPRI(facility syslog.Priority, sev map[Priority]int) error {
+	if facility < 0 {
+		l.mu.Lock()
+		l.pri = nil
+		l.mu.Unlock()
+		return nil
+	}
+
+	if facility&^_SYSLOG_FACMASK != 0 || facility > syslog.LOG_LOCAL7 {
+		return fmt.Errorf("%s: invalid syslog facility %d", l.Prefix(), facility)
+	}
+
+	p := &syslogPRI{fac: int(facility)}
+	for prio, s := range prioSeverity {
+		p.sev[prio] = s
+	}
+	for prio, s := range sev {
+		if prio <= LOG_NONE || prio >= logMax {
+			return fmt.Errorf("%s: invalid priority %d", l.Prefix(), prio)
+		}
+		if s < 0 || s > 7 {
+			return fmt.Errorf("%s: invalid syslog severity %d for %s", l.Prefix(), s, prio)
+		}
+		p.sev[prio] = s
+	}
+
+	l.mu.Lock()
+	l.pri = p
+	l.mu.Unlock()
+	return nil
+}
+
+// vim: ft=go:sw=8:ts=8:noexpandtab:tw=98:
@@ -0,0 +1,101 @@
+package logger
+
+import (
+	"bytes"
+	"log/syslog"
+	"net"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyslogFacility(t *testing.T) {
+	assert := newAsserter(t, "syslog-facility")
+
+	// a fake syslogd
+	sock := filepath.Join(t.TempDir(), "log.sock")
+	pc, err := net.ListenPacket("unixgram", sock)
+	assert(err == nil, "listen: %s", err)
+	defer pc.Close()
+
+	syslogNew = func(p syslog.Priority, tag string) (*syslog.Writer, error) {
+		return syslog.Dial("unixgram", sock, p, tag)
+	}
+	defer func() { syslogNew = syslog.New }()
+
+	cfg := &SyslogConfig{
+		Tag:      "app",
+		Facility: syslog.LOG_DAEMON,
+		Facilities: map[Priority]syslog.Priority{
+			LOG_ERR: syslog.LOG_AUTHPRIV,
+		},
+	}
+	ll, err := NewSyslogWithConfig(cfg, LOG_INFO, "", 0)
+	assert(err == nil, "can't create log: %s", err)
+
+	ll.Info("general")
+	ll.Error("security")
+	ll.Close()
+
+	pri := regexp.MustCompile(`^<(\d+)>.* app\[\d+\]: (\w+)`)
+	exp := map[string]syslog.Priority{
+		"general":  syslog.LOG_DAEMON | syslog.LOG_INFO,
+		"security": syslog.LOG_AUTHPRIV | syslog.LOG_ERR,
+	}
+
+	var buf [2048]byte
+	for len(exp) > 0 {
+		pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := pc.ReadFrom(buf[:])
+		assert(err == nil, "read: %s", err)
+
+		m := pri.FindSubmatch(buf[:n])
+		assert(m != nil, "malformed message %q", buf[:n])
+
+		p, ok := exp[string(m[2])]
+		if !ok {
+			continue
+		}
+
+		v, _ := strconv.Atoi(string(m[1]))
+		assert(syslog.Priority(v) == p, "%s: exp PRI %d, saw %d", m[2], p, v)
+		delete(exp, string(m[2]))
+	}
+}
+
+func TestSyslogPRI(t *testing.T) {
+	assert := newAsserter(t, "syslog-pri")
+
+	var b bytes.Buffer
+	ll, err := New(&b, LOG_DEBUG, "", Ltime)
+	assert(err == nil, "can't create log: %s", err)
+
+	x := ll.(*xLogger)
+	err = x.SetSyslogPRI(syslog.LOG_LOCAL0, map[Priority]int{LOG_DEBUG: 6})
+	assert(err == nil, "set pri: %s", err)
+
+	ll.Error("oops")
+	ll.Debug("details")
+	ll.New("sub", 0).Alert("down")
+
+	err = x.SetSyslogPRI(-1, nil)
+	assert(err == nil, "reset pri: %s", err)
+	ll.Error("plain")
+
+	assert(x.SetSyslogPRI(syslog.LOG_LOCAL0|syslog.LOG_ERR, nil) != nil, "accepted a severity in the facility")
+	assert(x.SetSyslogPRI(syslog.LOG_LOCAL0, map[Priority]int{LOG_ERR: 8}) != nil, "accepted an invalid severity")
+	assert(x.SetSyslogPRI(syslog.LOG_LOCAL0, map[Priority]int{LOG_NONE: 1}) != nil, "accepted an invalid priority")
+	ll.Close()
+
+	out := b.String()
+	for _, s := range []string{"<131>:", "<134>:", "<129>:"} {
+		assert(strings.Contains(out, s), "missing %s:\n%s", s, out)
+	}
+
+	// LOG_ERR with LOCAL0: 16*8 + 3
+	assert(regexp.MustCompile(`(?m)^<131>:\S+ oops$`).MatchString(out), "wrong PRI:\n%s", out)
+	assert(regexp.MustCompile(`(?m)^<5>:\S+ plain$`).MatchString(out), "PRI not reset:\n%s", out)
+}
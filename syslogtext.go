@@ -0,0 +1,82 @@
+// syslogtext.go - BSD syslog text output
+//
+// Copyright 2012, Sudhi Herle <sudhi -at- herle.net>
+// This code is licensed under the same terms as the golang core.
+
+package logger
+
+import (
+	"os"
+	"path"
+	"strconv"
+	"sync"
+)
+
+// SyslogTextFormatter renders log events as BSD syslog (RFC3164) lines
+// without the PRI - e.g., for a log file ingested by rsyslog's imfile:
+//
+//	Jan _2 15:04:05 host tag[pid]: [prefix] (file:line) message k=v..
+//
+// The timestamp is in UTC like the rest of the logger. The host and pid
+// are always present; Lhostname and Lpid are ignored. This is distinct
+// from the RFC5424 frames written by NewRFC5424().
+type SyslogTextFormatter struct {
+	// Tag identifies the program; it defaults to the program name. It
+	// must be at most 32 alphanumeric characters (or '.', '_', '-').
+	Tag string
+}
+
+var _ Formatter = SyslogTextFormatter{}
+
+// Format renders 'e' as a BSD syslog line
+func (f SyslogTextFormatter) Format(b []byte, e Event) []byte {
+	tag := f.Tag
+	if len(tag) == 0 {
+		tag = progTag()
+	}
+
+	b = e.Time.AppendFormat(b, "Jan _2 15:04:05")
+	b = append(b, ' ')
+	b = append(b, hostname()...)
+	b = append(b, ' ')
+	b = append(b, tag...)
+	b = append(b, '[')
+	b = strconv.AppendInt(b, int64(pid), 10)
+	b = append(b, "]: "...)
+
+	e.Flags &^= Lhostname | Lpid
+	b = appendBody(b, &e)
+	if len(e.fldstr) > 0 {
+		b = append(b, e.fldstr...)
+	} else {
+		b = appendFields(b, e.Fields)
+	}
+	return append(b, '\n')
+}
+
+// progTag returns the program name as a syslog tag
+var progTag = sync.OnceValue(func() string {
+	return syslogTag(path.Base(os.Args[0]))
+})
+
+// sanitize 's' for use as an RFC3164 tag
+func syslogTag(s string) string {
+	b := []byte(s)
+	if len(b) > 32 {
+		b = b[:32]
+	}
+	for i, c := range b {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '.', c == '_', c == '-':
+		default:
+			b[i] = '_'
+		}
+	}
+	if len(b) == 0 {
+		return "-"
+	}
+	return string(b)
+}
+
+// vim: ft=go:sw=8:ts=8:noexpandtab:tw=98:
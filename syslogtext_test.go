@@ -0,0 +1,42 @@
+package logger
+
+import (
+	"bytes"
+	"fmt"
+	re "regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyslogText(t *testing.T) {
+	assert := newAsserter(t, "syslogtext")
+
+	var b bytes.Buffer
+	ll, err := NewWithOptions(&b, LOG_DEBUG, "foo", Lfileloc, &Options{Quiet: true})
+	assert(err == nil, "can't create log: %s", err)
+
+	x := ll.(*xLogger)
+	x.SetClock(func() time.Time { return time.Date(2024, 3, 5, 7, 8, 9, 0, time.UTC) })
+	x.SetFormatter(SyslogTextFormatter{Tag: "myapp"})
+	ll.Info("hello world")
+	ll.WithFields(map[string]any{"user": "bob"}).Error("oops")
+	x.SetFormatter(SyslogTextFormatter{})
+	ll.Warn("default tag")
+	ll.Close()
+
+	lines := strings.Split(b.String(), "\n")
+	assert(len(lines) == 4, "exp 3 lines; saw:\n%s", b.String())
+
+	hdr := fmt.Sprintf(`^Mar  5 07:08:09 %s %%s\[%d\]: \[foo\] `, re.QuoteMeta(hostname()), pid)
+	rx := re.MustCompile(fmt.Sprintf(hdr, "myapp") + `\(syslogtext_test.go:\d+\) hello world$`)
+	assert(rx.MatchString(lines[0]), "info line mismatch: %q", lines[0])
+
+	rx = re.MustCompile(fmt.Sprintf(hdr, "myapp") + `\(syslogtext_test.go:\d+\) oops user=bob$`)
+	assert(rx.MatchString(lines[1]), "error line mismatch: %q", lines[1])
+
+	rx = re.MustCompile(`^[A-Z][a-z]{2} [ \d]\d \d\d:\d\d:\d\d \S+ ` + re.QuoteMeta(progTag()) + `\[\d+\]: `)
+	assert(rx.MatchString(lines[2]), "default tag mismatch: %q", lines[2])
+
+	assert(syslogTag("my app[1]:x") == "my_app_1__x", "tag not sanitized: %q", syslogTag("my app[1]:x"))
+}